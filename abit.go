@@ -4,11 +4,8 @@ package abit
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
-	"fmt"
 	"sort"
-
-	"github.com/multiformats/go-multibase"
+	"strings"
 )
 
 // ABITObject is used to store ABIT trees.
@@ -36,11 +33,6 @@ type ABITArray struct {
 // Null is a helper object to represent null values in abit.
 type Null struct{}
 
-// ABITLexicon stores a schema to see if a given ABITObject matches the schema.
-type ABITLexicon struct {
-	lexicon ABITObject
-}
-
 // NewABITObject Creates an ABIT object from a binary ABIT document.
 //
 // error is nil on success or an error if the document is invalid.
@@ -58,19 +50,7 @@ type ABITLexicon struct {
 //		// Code to handle a valid ABIT Document here
 //	}
 func NewABITObject(document *[]byte) (*ABITObject, error) {
-	if len(*document) > 0 {
-		tree, _, err := decodeTree(document, 0, false)
-		if err != nil {
-			return nil, err
-		}
-		return &([]ABITObject{tree}[0]), nil
-	} else {
-		tree := ABITObject{
-			dataType: 0b0110,
-			tree:     map[string]*ABITObject{},
-		}
-		return &([]ABITObject{tree}[0]), nil
-	}
+	return NewABITObjectWithOptions(document, DecodeOptions{})
 }
 
 // NewABITArray Initializes and returns an empty ABIT array.
@@ -255,7 +235,10 @@ func (t *ABITObject) get(key string) interface{} {
 	if t.dataType != 0b0110 {
 		panic("ABITObject is not of type tree")
 	}
-	o := t.tree[key]
+	o, ok := t.tree[key]
+	if !ok {
+		panic("key not found")
+	}
 	switch o.dataType {
 	case 0b0000:
 		return Null{}
@@ -485,26 +468,30 @@ func encodeBoolean(value bool) *[]byte {
 	}
 }
 
-func encodeInteger(value int64, type_n uint8) *[]byte {
-	var byteCount uint8 = 0
+// integerSize returns the minimum number of bytes needed to store value.
+func integerSize(value int64) uint8 {
 	switch {
 	case value >= -128 && value <= 127:
-		byteCount = 1
+		return 1
 	case value >= -32768 && value <= 32767:
-		byteCount = 2
+		return 2
 	case value >= -8388608 && value <= 8388607:
-		byteCount = 3
+		return 3
 	case value >= -2147483648 && value <= 2147483647:
-		byteCount = 4
+		return 4
 	case value >= -549755813888 && value <= 549755813887:
-		byteCount = 5
+		return 5
 	case value >= -140737488355328 && value <= 140737488355327:
-		byteCount = 6
+		return 6
 	case value >= -36028797018963968 && value <= 36028797018963967:
-		byteCount = 7
+		return 7
 	default:
-		byteCount = 8
+		return 8
 	}
+}
+
+func encodeInteger(value int64, type_n uint8) *[]byte {
+	byteCount := integerSize(value)
 
 	buf := make([]byte, 8)
 
@@ -556,20 +543,20 @@ func encodeArray(value *ABITArray) *[]byte {
 	return encodeBlob(&p, 0b0101)
 }
 
-func encodeTree(value *ABITObject, nested bool) *[]byte {
-	keys := make([]string, 0, len(value.tree))
-	for k := range value.tree {
+// sortedKeys returns the keys of a tree in the order they are encoded.
+func sortedKeys(tree map[string]*ABITObject) []string {
+	keys := make([]string, 0, len(tree))
+	for k := range tree {
 		keys = append(keys, k)
 	}
-
 	sort.Slice(keys, func(i, j int) bool {
-		if len(keys[i]) == len(keys[j]) {
-			// If lengths are equal, sort lexicographically
-			return keys[i] < keys[j]
-		}
-		// Otherwise, sort by length
-		return len(keys[i]) < len(keys[j])
+		return keyCompare(keys[i], keys[j])
 	})
+	return keys
+}
+
+func encodeTree(value *ABITObject, nested bool) *[]byte {
+	keys := sortedKeys(value.tree)
 
 	var buffer bytes.Buffer
 	for _, key := range keys {
@@ -612,36 +599,46 @@ func (t *ABITObject) ToByteArray() []byte {
 }
 
 func decodeKey(blob *[]byte, offset int64) (string, int64, error) {
+	key, offset, err := decodeKeyBytes(blob, offset)
+	return string(key), offset, err
+}
+
+// decodeKeyBytes decodes a key like decodeKey, returning it as a sub-slice
+// of blob.
+func decodeKeyBytes(blob *[]byte, offset int64) ([]byte, int64, error) {
 	if offset < 0 || int(offset) >= len(*blob) {
-		return "", 0, fmt.Errorf("key out of bounds")
+		return nil, 0, newDecodeError(offset, ErrTruncated)
 	}
 	keyLength := int64((*blob)[offset]) + 1
 	if int(offset+1+keyLength) > len(*blob) {
-		return "", 0, fmt.Errorf("key out of bounds")
+		return nil, 0, newDecodeError(offset, ErrTruncated)
 	}
-	return string((*blob)[offset+1 : offset+1+keyLength]), offset + 1 + keyLength, nil
+	return (*blob)[offset+1 : offset+1+keyLength], offset + 1 + keyLength, nil
 }
 
 func decodeType(blob *[]byte, offset int64) (uint8, error) {
 	if offset < 0 || int(offset) >= len(*blob) {
-		return 0, fmt.Errorf("type exceeds blob")
+		return 0, newDecodeError(offset, ErrTruncated)
 	}
 	return (*blob)[offset] & 0x0f, nil
 }
 
 func decodeNull(blob *[]byte, offset int64) (int64, error) {
 	if offset < 0 || int(offset) >= len(*blob) {
-		return 0, fmt.Errorf("null exceeds blob")
+		return 0, newDecodeError(offset, ErrTruncated)
 	}
 	if (*blob)[offset] != 0x00 {
-		return 0, fmt.Errorf("byte is not null")
+		err := newDecodeError(offset, ErrInvalidValue)
+		err.Expected = KindNull
+		err.Found = Kind((*blob)[offset] & 0x0f)
+		return 0, err
 	}
 	return offset + 1, nil
 }
 
 func decodeBoolean(blob *[]byte, offset int64) (bool, int64, error) {
 	if offset < 0 || int(offset) >= len(*blob) {
-		return false, 0, fmt.Errorf("bool exceeds blob")
+		return false, 0, newDecodeError(offset, ErrTruncated)
 	}
 	switch (*blob)[offset] {
 	case 0b00010001:
@@ -649,33 +646,45 @@ func decodeBoolean(blob *[]byte, offset int64) (bool, int64, error) {
 	case 0b00000001:
 		return false, offset + 1, nil
 	}
-	return false, 0, fmt.Errorf("byte is not boolean")
+	err := newDecodeError(offset, ErrInvalidValue)
+	err.Expected = KindBoolean
+	err.Found = Kind((*blob)[offset] & 0x0f)
+	return false, 0, err
 }
 
 func decodeInteger(blob *[]byte, offset int64, maxSize int) (int64, int64, error) {
 	if offset < 0 || int(offset) >= len(*blob) {
-		return 0, 0, fmt.Errorf("integer exceeds blob")
+		return 0, 0, newDecodeError(offset, ErrTruncated)
 	}
 	intSize := ((*blob)[offset] >> 4) + 1
 	if maxSize < int(intSize) {
-		return 0, 0, fmt.Errorf("integer is too big at %d", offset)
+		err := newDecodeError(offset, ErrIntegerTooLarge)
+		err.Found = Kind((*blob)[offset] & 0x0f)
+		return 0, 0, err
 	}
 	if int(offset+1+int64(intSize)) > len(*blob) {
-		return 0, 0, fmt.Errorf("integer is out of bounds")
+		return 0, 0, newDecodeError(offset, ErrTruncated)
 	}
 
+	result := integerFromBytes((*blob)[offset+1 : offset+1+int64(intSize)])
+	return result, offset + 1 + int64(intSize), nil
+}
+
+// integerFromBytes converts the 1 to 8 little-endian bytes of an integer,
+// sign-extending them.
+func integerFromBytes(b []byte) int64 {
 	extended := make([]byte, 8)
-	copy(extended, (*blob)[offset+1:offset+1+int64(intSize)])
+	copy(extended, b)
 
 	// If the sign bit (most significant bit of the original byte slice) is set, perform sign-extension
-	if (*blob)[offset+1+int64(intSize)-1]&0x80 != 0 {
-		for i := intSize; i < 8; i++ {
+	if b[len(b)-1]&0x80 != 0 {
+		for i := len(b); i < 8; i++ {
 			extended[i] = 0xFF
 		}
 	}
 
 	// Convert to int64 by interpreting the extended slice as a little-endian 8-byte integer
-	result := int64(extended[0]) |
+	return int64(extended[0]) |
 		int64(extended[1])<<8 |
 		int64(extended[2])<<16 |
 		int64(extended[3])<<24 |
@@ -683,535 +692,331 @@ func decodeInteger(blob *[]byte, offset int64, maxSize int) (int64, int64, error
 		int64(extended[5])<<40 |
 		int64(extended[6])<<48 |
 		int64(extended[7])<<56
+}
 
-	return result, offset + 1 + int64(intSize), nil
+// decodeLength decodes the length prefix of a blob, string, array or tree
+// and checks that the contents fit in the blob.
+func decodeLength(blob *[]byte, offset int64) (int64, int64, error) {
+	length, start, err := decodeInteger(blob, offset, 4)
+	if err != nil {
+		return 0, 0, err
+	}
+	if length < 0 {
+		err := newDecodeError(offset, ErrNegativeLength)
+		err.Found = Kind((*blob)[offset] & 0x0f)
+		return 0, 0, err
+	}
+	if len(*blob) < int(start+length) {
+		return 0, 0, newDecodeError(int64(len(*blob)), ErrTruncated)
+	}
+	return length, start, nil
 }
 
 func decodeBlob(blob *[]byte, offset int64) ([]byte, int64, error) {
-	blobLength, offset, err := decodeInteger(blob, offset, 4)
+	blobLength, offset, err := decodeLength(blob, offset)
 	if err != nil {
 		return nil, 0, err
 	}
-	if blobLength < 0 {
-		return nil, 0, fmt.Errorf("negative length for blob")
-	}
-	if len(*blob) < int(offset+blobLength) {
-		return nil, 0, fmt.Errorf("length for blob exceeds the blob")
-	}
 	var buf []byte = (*blob)[offset : offset+blobLength]
 	return buf, offset + blobLength, nil
 }
 
-func decodeString(blob *[]byte, offset int64) (string, int64, error) {
-	rawString, offset, err := decodeBlob(blob, offset)
-	return string(rawString), offset, err
+// decodeSource is a document being decoded, held in memory or read from a
+// stream. Reads that go past the end of the document return a DecodeError
+// with ErrTruncated.
+type decodeSource interface {
+	// offset returns the offset of the next byte in the document.
+	offset() int64
+	// atEnd reports whether the document ends before the next byte.
+	atEnd() (bool, error)
+	// readByte returns the next byte.
+	readByte() (byte, error)
+	// read returns the next n bytes. The bytes may be part of the document
+	// held in memory.
+	read(n int64) ([]byte, error)
 }
 
-func decodeArray(blob *[]byte, offset int64) (ABITArray, int64, error) {
-	arr := ABITArray{}
-	arrBlob, offset, err := decodeBlob(blob, offset)
-	if err != nil {
-		return arr, 0, err
-	}
-	var index int64 = 0
-	for int(index) < len(arrBlob) {
-		typ, err := decodeType(&arrBlob, index)
-		if err != nil {
-			return arr, 0, err
-		}
-		switch typ {
-		case 0b0000:
-			index, err = decodeNull(&arrBlob, index)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 0,
-			})
-		case 0b0001:
-			var b bool
-			b, index, err = decodeBoolean(&arrBlob, index)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 1,
-				boolean:  b,
-			})
-		case 0b0010:
-			var b int64
-			b, index, err = decodeInteger(&arrBlob, index, 8)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 2,
-				integer:  b,
-			})
-		case 0b0011:
-			var b []byte
-			b, index, err = decodeBlob(&arrBlob, index)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 3,
-				blob:     &([]([]byte){b}[0]),
-			})
-		case 0b0100:
-			var b string
-			b, index, err = decodeString(&arrBlob, index)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 4,
-				text:     &([]string{b}[0]),
-			})
-		case 0b0101:
-			var b ABITArray
-			b, index, err = decodeArray(&arrBlob, index)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &ABITObject{
-				dataType: 5,
-				array:    &([]ABITArray{b}[0]),
-			})
-		case 0b0110:
-			var b ABITObject
-			b, index, err = decodeTree(&arrBlob, index, true)
-			if err != nil {
-				return arr, 0, err
-			}
-			arr.array = append(arr.array, &([]ABITObject{b}[0]))
-		default:
-			return arr, 0, fmt.Errorf("invalid type")
-		}
-	}
-	if int(index) > len(arrBlob) {
-		return arr, 0, fmt.Errorf("corrupt array")
-	}
-	return arr, offset, nil
+// sliceSource is a document held in memory.
+type sliceSource struct {
+	doc []byte
+	pos int64
 }
 
-func keyCompare(a, b string) bool {
-	if len(a) == len(b) {
-		// If lengths are equal, sort lexicographically
-		return a < b
-	}
-	// Otherwise, sort by length
-	return len(a) < len(b)
+func (src *sliceSource) offset() int64 {
+	return src.pos
 }
 
-func decodeTree(blob *[]byte, offset int64, nested bool) (ABITObject, int64, error) {
-	tree := ABITObject{
-		dataType: 6,
-		tree:     map[string]*ABITObject{},
-	}
-
-	var err error
-	var index int64 = 0
-	if nested {
-		index = offset
-		var treeSize int64
-		treeSize, index, err = decodeInteger(blob, offset, 4)
-		offset = index + treeSize
-		if err != nil {
-			return tree, 0, err
-		}
-	} else {
-		offset = int64(len(*blob))
-	}
-
-	var key, lastKey string = "", ""
-	for index < offset {
-		key, index, err = decodeKey(blob, index)
-		if err != nil {
-			return tree, 0, err
-		}
-
-		if !keyCompare(lastKey, key) {
-			return tree, 0, fmt.Errorf("invalid key order: (%d)->(%d), %s -> %s", len(lastKey), len(key), lastKey, key)
-		}
-		lastKey = key
+func (src *sliceSource) atEnd() (bool, error) {
+	return int(src.pos) >= len(src.doc), nil
+}
 
-		typ, err := decodeType(blob, index)
-		if err != nil {
-			return tree, 0, err
-		}
-		switch typ {
-		case 0b0000:
-			index, err = decodeNull(blob, index)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 0,
-			}
-		case 0b0001:
-			var b bool
-			b, index, err = decodeBoolean(blob, index)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 1,
-				boolean:  b,
-			}
-		case 0b0010:
-			var b int64
-			b, index, err = decodeInteger(blob, index, 8)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 2,
-				integer:  b,
-			}
-		case 0b0011:
-			var b []byte
-			b, index, err = decodeBlob(blob, index)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 3,
-				blob:     &([]([]byte){b}[0]),
-			}
-		case 0b0100:
-			var b string
-			b, index, err = decodeString(blob, index)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 4,
-				text:     &([]string{b}[0]),
-			}
-		case 0b0101:
-			var b ABITArray
-			b, index, err = decodeArray(blob, index)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &ABITObject{
-				dataType: 5,
-				array:    &([]ABITArray{b}[0]),
-			}
-		case 0b0110:
-			var b ABITObject
-			b, index, err = decodeTree(blob, index, true)
-			if err != nil {
-				return tree, 0, err
-			}
-			tree.tree[key] = &([]ABITObject{b}[0])
-		default:
-			return tree, 0, fmt.Errorf("invalid type")
-		}
+func (src *sliceSource) readByte() (byte, error) {
+	if int(src.pos) >= len(src.doc) {
+		return 0, newDecodeError(int64(len(src.doc)), ErrTruncated)
 	}
-	if int(index) > len(*blob) {
-		return tree, 0, fmt.Errorf("corrupt array")
-	}
-	return tree, offset, nil
+	src.pos++
+	return src.doc[src.pos-1], nil
 }
 
-// InitLexicon creates an ABITLexicon used for seeing if any ABITObject follows the given schema or not.
-//
-//   - lexicon is a json with the specified scema.
-//   - Returns ABITLexicon
-//
-// # Allowed types:
-//   - "null"
-//   - "boolean"
-//   - "integer"
-//   - "blob"
-//   - "string"
-//
-// # Example:
-//
-//	abit.InitLexicon(`{
-//		"key1":"boolean",
-//		"key2":{
-//			"nested":"key"
-//		},
-//		"key3":[
-//			"string"
-//			"string"
-//			"null"
-//		]
-//	}`)
-func InitLexicon(lexicon string) ABITLexicon {
-	// Unmarshal JSON into a map
-	var lexiconMap map[string]interface{}
-	err := json.Unmarshal([]byte(lexicon), &lexiconMap)
-	if err != nil {
-		panic(err.Error())
+func (src *sliceSource) read(n int64) ([]byte, error) {
+	if int64(len(src.doc))-src.pos < n {
+		return nil, newDecodeError(int64(len(src.doc)), ErrTruncated)
 	}
+	src.pos += n
+	return src.doc[src.pos-n : src.pos], nil
+}
 
-	return ABITLexicon{
-		lexicon: jsonTypeTreeToABIT(lexiconMap),
+// readByte reads the next byte of src within the size limit of the document.
+func (s *decodeState) readByte(src decodeSource) (byte, error) {
+	if err := s.checkDocumentSize(src.offset() + 1); err != nil {
+		return 0, err
 	}
+	return src.readByte()
 }
 
-func jsonTypeArrayToABIT(lexicon []interface{}) ABITArray {
-	arr := NewABITArray()
-
-	for i := range lexicon {
-		switch t := lexicon[i].(type) {
-		case string:
-			switch t {
-			case "null":
-				arr.Add(Null{})
-			case "boolean":
-				arr.Add(false)
-			case "integer":
-				arr.Add(int64(0))
-			case "blob":
-				arr.Add([]byte{})
-			case "string":
-				arr.Add("")
-			default:
-				panic("value must be any of: \"null\", \"boolean\", \"integer\", \"blob\", \"string\"")
-			}
-		case []interface{}: // Array
-			arr.Add(jsonTypeArrayToABIT(t))
-		case map[string]interface{}: // Tree
-			arr.Add(jsonTypeTreeToABIT(t))
-		default:
-			panic("value to every key in lexicon must be either a string, array or tree")
-		}
+// read reads the next n bytes of src within the size limit of the document.
+func (s *decodeState) read(src decodeSource, n int64) ([]byte, error) {
+	if err := s.checkDocumentSize(src.offset() + n); err != nil {
+		return nil, err
 	}
-
-	return *arr
+	return src.read(n)
 }
 
-func jsonTypeTreeToABIT(lexicon map[string]interface{}) ABITObject {
-	// Create ABITObject
-	tree, err := NewABITObject(&[]byte{})
+// readInteger reads the bytes of the integer or length prefix starting with
+// typeByte at start, which was already read.
+func (s *decodeState) readInteger(src decodeSource, start int64, typeByte byte, maxSize int) (int64, error) {
+	intSize := int64(typeByte>>4) + 1
+	if intSize > int64(maxSize) {
+		err := newDecodeError(start, ErrIntegerTooLarge)
+		err.Found = Kind(typeByte & 0x0f)
+		return 0, err
+	}
+	b, err := s.read(src, intSize)
 	if err != nil {
-		panic(err.Error())
+		return 0, err
 	}
-	keys := make([]string, 0, len(lexicon))
-	for k := range lexicon {
-		keys = append(keys, k)
+	value := integerFromBytes(b)
+	if err := s.checkIntegerByte(start, typeByte, value); err != nil {
+		return 0, err
 	}
-	for i := range keys {
-		switch t := lexicon[keys[i]].(type) {
-		case string:
-			switch t {
-			case "null":
-				tree.Put(keys[i], Null{})
-			case "boolean":
-				tree.Put(keys[i], false)
-			case "integer":
-				tree.Put(keys[i], int64(0))
-			case "blob":
-				tree.Put(keys[i], []byte{})
-			case "string":
-				tree.Put(keys[i], "")
-			default:
-				panic("Value must be any of: \"null\", \"boolean\", \"integer\", \"blob\", \"string\"")
-			}
-		case []interface{}: // Array
-			tree.Put(keys[i], jsonTypeArrayToABIT(t))
-		case map[string]interface{}: // Tree
-			tree.Put(keys[i], jsonTypeTreeToABIT(t))
-		default:
-			panic("Value to every key in lexicon must be either a string, array or tree")
-		}
-	}
-	return *tree
-}
-
-// Matches checks if the given ABITObject matches the schema in the lexicon.
-//
-//   - Returns true if matches, returns false otherwise.
-//
-// # Example:
-//
-//	if lex.Matches(doc) {
-//		// functions to handle the valid abit document here.
-//	}
-func (l *ABITLexicon) Matches(doc *ABITObject) bool {
-	return matchTree(&l.lexicon, doc)
+	return value, nil
 }
 
-func matchTree(a *ABITObject, b *ABITObject) bool {
-	keys1 := make([]string, 0, len(a.tree))
-	for k := range a.tree {
-		keys1 = append(keys1, k)
+// readLength reads the length prefix of a blob, string, array or tree
+// starting with typeByte at start, which was already read.
+func (s *decodeState) readLength(src decodeSource, start int64, typeByte byte) (int64, error) {
+	length, err := s.readInteger(src, start, typeByte, 4)
+	if err != nil {
+		return 0, err
 	}
-
-	keys2 := make([]string, 0, len(b.tree))
-	for k := range b.tree {
-		keys2 = append(keys2, k)
+	if length < 0 {
+		err := newDecodeError(start, ErrNegativeLength)
+		err.Found = Kind(typeByte & 0x0f)
+		return 0, err
 	}
+	return length, nil
+}
 
-	// Same number of items?
-	if len(keys1) != len(keys2) {
-		return false
+// decodeValue decodes the value at the next byte of src, whatever its type.
+func (s *decodeState) decodeValue(src decodeSource) (*ABITObject, error) {
+	start := src.offset()
+	typeByte, err := s.readByte(src)
+	if err != nil {
+		return nil, err
 	}
-
-	sort.Slice(keys1, func(i, j int) bool {
-		if len(keys1[i]) == len(keys1[j]) {
-			// If lengths are equal, sort lexicographically
-			return keys1[i] < keys1[j]
+	if err = s.countElement(start); err != nil {
+		return nil, err
+	}
+	typ := typeByte & 0x0f
+	switch typ {
+	case 0b0000:
+		if typeByte != 0x00 {
+			return nil, invalidValue(start, KindNull, typeByte)
 		}
-		// Otherwise, sort by length
-		return len(keys1[i]) < len(keys1[j])
-	})
-
-	sort.Slice(keys2, func(i, j int) bool {
-		if len(keys2[i]) == len(keys2[j]) {
-			// If lengths are equal, sort lexicographically
-			return keys2[i] < keys2[j]
+		return &ABITObject{
+			dataType: 0,
+		}, nil
+	case 0b0001:
+		if typeByte != 0b00010001 && typeByte != 0b00000001 {
+			return nil, invalidValue(start, KindBoolean, typeByte)
 		}
-		// Otherwise, sort by length
-		return len(keys2[i]) < len(keys2[j])
-	})
-
-	// Are keys identical?
-	for i := int64(0); int(i) < len(keys1); i++ {
-		if keys1[i] != keys2[i] {
-			return false
+		return &ABITObject{
+			dataType: 1,
+			boolean:  typeByte == 0b00010001,
+		}, nil
+	case 0b0010:
+		b, err := s.readInteger(src, start, typeByte, 8)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	for i := range keys1 {
-		if a.tree[keys1[i]].dataType != b.tree[keys1[i]].dataType {
-			return false
+		return &ABITObject{
+			dataType: 2,
+			integer:  b,
+		}, nil
+	case 0b0011, 0b0100:
+		length, err := s.readLength(src, start, typeByte)
+		if err != nil {
+			return nil, err
 		}
-
-		switch a.tree[keys1[i]].dataType {
-		case 0b0101: // Array
-			if !matchArray(a.tree[keys1[i]], b.tree[keys1[i]]) {
-				return false
-			}
-		case 0b0110: // Tree
-			if !matchTree(a.tree[keys1[i]], b.tree[keys1[i]]) {
-				return false
+		if err = s.checkBlobSize(start, length); err != nil {
+			return nil, err
+		}
+		b, err := s.read(src, length)
+		if err != nil {
+			return nil, err
+		}
+		if typ == 0b0011 {
+			return &ABITObject{
+				dataType: 3,
+				blob:     &b,
+			}, nil
+		}
+		text := string(b)
+		if err = s.checkText(start, text); err != nil {
+			return nil, err
+		}
+		return &ABITObject{
+			dataType: 4,
+			text:     &text,
+		}, nil
+	case 0b0101, 0b0110:
+		if err := s.enter(start); err != nil {
+			return nil, err
+		}
+		defer s.leave()
+		length, err := s.readLength(src, start, typeByte)
+		if err != nil {
+			return nil, err
+		}
+		end := src.offset() + length
+		if typ == 0b0101 {
+			b, err := s.decodeArray(src, end)
+			if err != nil {
+				return nil, err
 			}
+			return &ABITObject{
+				dataType: 5,
+				array:    &b,
+			}, nil
+		}
+		b, err := s.decodeTree(src, end)
+		if err != nil {
+			return nil, err
 		}
+		return &b, nil
+	default:
+		err := newDecodeError(start, ErrInvalidType)
+		err.Found = Kind(typ)
+		return nil, err
 	}
-
-	return true
 }
 
-func matchArray(a *ABITObject, b *ABITObject) bool {
-	if a.dataType != 0b0101 || b.dataType != 0b0101 {
-		return false
-	}
-
-	if len(a.array.array) != len(b.array.array) {
-		return false
-	}
+func invalidValue(offset int64, expected Kind, typeByte byte) error {
+	err := newDecodeError(offset, ErrInvalidValue)
+	err.Expected = expected
+	err.Found = Kind(typeByte & 0x0f)
+	return err
+}
 
-	for i := range a.array.array {
-		if a.array.array[i].dataType != b.array.array[i].dataType {
-			return false
+// decodeArray decodes the values of an array from src, up to the offset end.
+func (s *decodeState) decodeArray(src decodeSource, end int64) (ABITArray, error) {
+	arr := ABITArray{}
+	for src.offset() < end {
+		start := src.offset()
+		obj, err := s.decodeValue(src)
+		if err != nil {
+			return arr, locateDecodeError(err, 0, indexSegment(len(arr.array)))
 		}
-
-		switch a.array.array[i].dataType {
-		case 0b0101: // Array
-			if !matchArray(a.array.array[i], b.array.array[i]) {
-				return false
-			}
-		case 0b0110: // Tree
-			if !matchTree(a.array.array[i], b.array.array[i]) {
-				return false
-			}
+		if src.offset() > end {
+			err := newDecodeError(start, ErrOverrun)
+			err.Path = indexSegment(len(arr.array))
+			return arr, err
 		}
+		arr.array = append(arr.array, obj)
 	}
-
-	return true
+	return arr, nil
 }
 
-func (a *ABITObject) ToJson() string {
-	if a.dataType != 0b0110 {
-		panic("ABITObject of invalid type for this function")
+func keyCompare(a, b string) bool {
+	if len(a) == len(b) {
+		// If lengths are equal, sort lexicographically
+		return a < b
 	}
+	// Otherwise, sort by length
+	return len(a) < len(b)
+}
 
-	keys := make([]string, 0, len(a.tree))
-	for k := range a.tree {
-		keys = append(keys, k)
+// decodeTree decodes the keys of a tree from src, up to the offset end. The
+// tree is the document itself if end is negative, and ends with src.
+func (s *decodeState) decodeTree(src decodeSource, end int64) (ABITObject, error) {
+	tree := ABITObject{
+		dataType: 6,
+		tree:     map[string]*ABITObject{},
 	}
 
-	sort.Slice(keys, func(i, j int) bool {
-		if len(keys[i]) == len(keys[j]) {
-			// If lengths are equal, sort lexicographically
-			return keys[i] < keys[j]
-		}
-		// Otherwise, sort by length
-		return len(keys[i]) < len(keys[j])
-	})
-
-	var out string = "{"
-	for _, key := range keys {
-		safeKey, err := json.Marshal(key)
-		if err != nil {
-			panic(err.Error())
+	lastKey := ""
+	for {
+		if end >= 0 && src.offset() >= end {
+			break
 		}
-		safeKeyString := string(safeKey)
-		switch a.tree[key].dataType {
-		case 0b0000:
-			out += fmt.Sprintf("%s:null,", safeKeyString)
-		case 0b0001:
-			out += fmt.Sprintf("%s:%t,", safeKeyString, a.tree[key].boolean)
-		case 0b0010:
-			out += fmt.Sprintf("%s:%d,", safeKeyString, a.tree[key].integer)
-		case 0b0011:
-			blobString, err := multibase.Encode(multibase.Base58BTC, *a.tree[key].blob)
+		if end < 0 {
+			done, err := src.atEnd()
 			if err != nil {
-				panic(err.Error())
+				return tree, err
 			}
-			out += fmt.Sprintf("%s:\"%s\",", safeKeyString[:len(safeKeyString)-1]+"_b\"", blobString)
-		case 0b0100:
-			safeString, err := json.Marshal(a.tree[key].text)
-			if err != nil {
-				panic(err.Error())
+			if done {
+				break
 			}
-			//out += fmt.Sprintf("%s:%s,", safeKeyString, strings.ReplaceAll(string(safeString), "\n", "\\n"))
-			out += fmt.Sprintf("%s:%s,", safeKeyString, string(safeString))
-		case 0b0101:
-			out += fmt.Sprintf("%s:%s,", safeKeyString, a.tree[key].array.toJsonArray())
-		case 0b0110:
-			out += fmt.Sprintf("%s:%s,", safeKeyString, a.tree[key].ToJson())
 		}
-	}
+		start := src.offset()
+		length, err := s.readByte(src)
+		if err != nil {
+			return tree, err
+		}
+		b, err := s.read(src, int64(length)+1)
+		if err != nil {
+			return tree, err
+		}
+		key := string(b)
 
-	return out[:len(out)-1] + "}"
-}
+		if !keyCompare(lastKey, key) {
+			err := newDecodeError(start, ErrKeyOrder)
+			err.Path = key
+			return tree, err
+		}
+		lastKey = key
+		if err = s.checkText(start, key); err != nil {
+			return tree, locateDecodeError(err, 0, key)
+		}
+		if err = s.checkKeys(start, len(tree.tree)+1); err != nil {
+			return tree, err
+		}
 
-func (a *ABITArray) toJsonArray() string {
-	var out string = "["
-	for _, obj := range a.array {
-		switch obj.dataType {
-		case 0b0000:
-			out += "null,"
-		case 0b0001:
-			out += fmt.Sprintf("%t,", obj.boolean)
-		case 0b0010:
-			out += fmt.Sprintf("%d,", obj.integer)
-		case 0b0011:
-			blobString, err := multibase.Encode(multibase.Base58BTC, *obj.blob)
-			if err != nil {
-				panic(err.Error())
-			}
-			out += fmt.Sprintf("\"%s\",", blobString)
-		case 0b0100:
-			safeString, err := json.Marshal(obj.text)
-			if err != nil {
-				panic(err.Error())
-			}
-			//out += fmt.Sprintf("%s,", strings.ReplaceAll(string(safeString), "\n", "\\n"))
-			out += fmt.Sprintf("%s,", string(safeString))
-		case 0b0101:
-			out += fmt.Sprintf("%s,", obj.array.toJsonArray())
-		case 0b0110:
-			out += fmt.Sprintf("%s,", obj.ToJson())
+		obj, err := s.decodeValue(src)
+		if err != nil {
+			return tree, locateDecodeError(err, 0, key)
+		}
+		if end >= 0 && src.offset() > end {
+			err := newDecodeError(start, ErrOverrun)
+			err.Path = key
+			return tree, err
 		}
+		tree.tree[key] = obj
+	}
+	return tree, nil
+}
+
+// ToJson returns the JSON representation of the tree. Blobs are written as
+// Base58BTC multibase strings and their keys get a "_b" suffix, use a
+// JSONEncoder to change how the JSON is written.
+func (a *ABITObject) ToJson() string {
+	if a.dataType != 0b0110 {
+		panic("ABITObject of invalid type for this function")
 	}
 
-	return out[:len(out)-1] + "]"
+	var out strings.Builder
+	if err := NewJSONEncoder(&out).Encode(a); err != nil {
+		panic(err.Error())
+	}
+	return out.String()
 }
@@ -0,0 +1,469 @@
+package abit
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseATProtoLexicon creates an ABITLexicon from an AT Protocol lexicon
+// document, such as {"lexicon":1,"id":"com.example.post","defs":{...}}. The
+// id of the document becomes the id of the lexicon and its definitions the
+// definitions of the lexicon, so references between AT Protocol lexicons are
+// resolved by adding them to a Registry.
+//
+// The types that map onto ABIT are converted:
+//   - null, boolean, integer and string are the ABIT types of the same name,
+//     with const, enum, minimum, maximum, minLength and maxLength. The string
+//     formats "uri" and "datetime" are the formats of lexicons. The formats
+//     "at-uri", "did", "handle", "at-identifier", "nsid", "cid", "tid",
+//     "record-key" and "language" become patterns checking their syntax and
+//     a maxLength of their maximum length, nothing is resolved
+//   - bytes is a blob, with minLength and maxLength as the size in bytes
+//   - blob is a reference to a blob stored elsewhere, a tree with the keys
+//     "$type" holding "blob", "ref" holding a tree with the CID of the blob as
+//     a string under "$link", "mimeType" and "size". accept lists the allowed
+//     MIME types, such as "image/*", and maxSize bounds size
+//   - array is an array, with minLength and maxLength as the number of items
+//   - object is a tree, keys not in required are optional and keys in nullable
+//     may also be null
+//   - record is the tree of its object, with an optional "$type" key holding
+//     the id of the lexicon
+//   - ref is a reference to a definition
+//   - union is a union discriminated by the "$type" key, which holds the id of
+//     the lexicon and the name of the definition, such as "com.example.post" or
+//     "com.example.post#image". Unions that are not closed fall back to a tree
+//     with any keys for every other "$type"
+//   - unknown is a tree with any keys
+//
+// Definitions of type token are skipped, they do not describe values.
+// description, default, knownValues and the key of records do not constrain
+// values and are ignored. minGraphemes and maxGraphemes are ignored too, as
+// lexicons do not count graphemes, so only the maxLength in UTF-8 bytes that
+// AT Protocol lexicons give next to them is checked. Anything else, such as
+// query definitions, returns an error naming the construct and where it is
+// in the document.
+//
+// # Example
+//
+//	data, err := os.ReadFile("lexicons/com/example/post.json")
+//	if err != nil {
+//		// Handle missing file here
+//	}
+//	lex, err := abit.ParseATProtoLexicon(data)
+//	if err != nil {
+//		// Handle unsupported lexicon here
+//	}
+func ParseATProtoLexicon(data []byte) (*ABITLexicon, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	source, err := atprotoToLexSource(value)
+	if err != nil {
+		return nil, err
+	}
+	return parseLexicon(source)
+}
+
+// atprotoKeywords holds the keywords of the supported AT Protocol types.
+// Keywords mapping to false are ignored.
+var atprotoKeywords = map[string]map[string]bool{
+	"null":    {},
+	"boolean": {"const": true, "default": false},
+	"integer": {"const": true, "enum": true, "minimum": true, "maximum": true, "default": false},
+	"string": {"const": true, "enum": true, "minLength": true, "maxLength": true, "format": true,
+		"minGraphemes": false, "maxGraphemes": false, "knownValues": false, "default": false},
+	"bytes":   {"minLength": true, "maxLength": true},
+	"blob":    {"accept": true, "maxSize": true},
+	"array":   {"items": true, "minLength": true, "maxLength": true},
+	"object":  {"properties": true, "required": true, "nullable": true},
+	"record":  {"record": true, "key": false},
+	"ref":     {"ref": true},
+	"union":   {"refs": true, "closed": true},
+	"unknown": {},
+}
+
+// atprotoFormat is how an AT Protocol string format is checked, with a
+// format of lexicons or with a pattern and a maximum length in bytes.
+type atprotoFormat struct {
+	format    string
+	pattern   string
+	maxLength int64
+}
+
+// The syntax of the AT Protocol identifiers, from their specifications.
+const (
+	atprotoDID       = `did:[a-z]+:[a-zA-Z0-9._:%-]*[a-zA-Z0-9._-]`
+	atprotoHandle    = `([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)+[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?`
+	atprotoNSID      = `[a-zA-Z]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)+\.[a-zA-Z][a-zA-Z0-9]{0,62}`
+	atprotoRecordKey = `[a-zA-Z0-9_~.:-]{3,}|[a-zA-Z0-9_~:-][a-zA-Z0-9_~.:-]?|\.[a-zA-Z0-9_~:-]`
+)
+
+// atprotoFormats holds the supported AT Protocol string formats.
+var atprotoFormats = map[string]atprotoFormat{
+	"uri":           {format: "uri"},
+	"datetime":      {format: "datetime"},
+	"did":           {pattern: atprotoDID, maxLength: 2048},
+	"handle":        {pattern: atprotoHandle, maxLength: 253},
+	"at-identifier": {pattern: atprotoDID + "|" + atprotoHandle, maxLength: 2048},
+	"nsid":          {pattern: atprotoNSID, maxLength: 317},
+	"at-uri": {
+		pattern:   "at://(" + atprotoDID + "|" + atprotoHandle + ")(/" + atprotoNSID + "(/(" + atprotoRecordKey + "))?)?",
+		maxLength: 8192,
+	},
+	"cid":        {pattern: `[a-zA-Z0-9+=]{8,256}`},
+	"tid":        {pattern: `[234567abcdefghij][234567abcdefghijklmnopqrstuvwxyz]{12}`},
+	"record-key": {pattern: atprotoRecordKey, maxLength: 512},
+	"language":   {pattern: `(i|[a-z]{2,3})(-[a-zA-Z0-9]+)*`},
+}
+
+// atprotoConverter converts the definitions of an AT Protocol lexicon.
+type atprotoConverter struct {
+	// id is the id of the lexicon, which references without an id refer to.
+	id string
+}
+
+// atprotoToLexSource converts an AT Protocol lexicon in the format of
+// decodeJSON to the JSON format of lexicons.
+func atprotoToLexSource(value interface{}) (map[string]interface{}, error) {
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("AT Protocol lexicon must be a JSON object")
+	}
+	for _, key := range sortedSourceKeys(doc) {
+		switch key {
+		case "lexicon", "id", "defs", "description", "revision":
+		default:
+			return nil, fmt.Errorf("%s at root is not supported", key)
+		}
+	}
+	if version, ok := doc["lexicon"].(json.Number); !ok || version.String() != "1" {
+		return nil, fmt.Errorf("lexicon at root must be 1")
+	}
+	id, ok := doc["id"].(string)
+	if !ok || id == "" || strings.Contains(id, "#") {
+		return nil, fmt.Errorf("id at root must be a non-empty string without \"#\"")
+	}
+	defs, ok := doc["defs"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("defs at root must be an object")
+	}
+
+	c := &atprotoConverter{id: id}
+	converted := make(map[string]interface{}, len(defs))
+	for _, name := range sortedSourceKeys(defs) {
+		path := appendPath("defs", name)
+		if def, ok := defs[name].(map[string]interface{}); ok && def["type"] == "token" {
+			continue
+		}
+		if def, ok := defs[name].(map[string]interface{}); ok && def["type"] == "record" && name != "main" {
+			return nil, fmt.Errorf("record at %s must be the main definition", path)
+		}
+		schema, err := c.schema(defs[name], path)
+		if err != nil {
+			return nil, err
+		}
+		converted[name] = schema
+	}
+	if _, ok := converted["main"]; !ok {
+		return nil, fmt.Errorf("lexicon %s has no main definition", id)
+	}
+	return map[string]interface{}{
+		lexVersionKey: json.Number(strconv.Itoa(lexVersion)),
+		"id":          id,
+		"defs":        converted,
+	}, nil
+}
+
+// schema converts the AT Protocol schema of a value.
+func (c *atprotoConverter) schema(value interface{}, path string) (interface{}, error) {
+	def, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema at %s must be an object", pathOrRoot(path))
+	}
+	typ, ok := def["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("schema at %s is missing type", pathOrRoot(path))
+	}
+	keywords, ok := atprotoKeywords[typ]
+	if !ok {
+		return nil, fmt.Errorf("type %q at %s is not supported", typ, pathOrRoot(path))
+	}
+	for _, key := range sortedSourceKeys(def) {
+		if _, ok := keywords[key]; !ok && key != "type" && key != "description" {
+			return nil, fmt.Errorf("%s at %s is not supported for type %q", key, pathOrRoot(path), typ)
+		}
+	}
+
+	switch typ {
+	case "null":
+		return "null", nil
+	case "boolean", "integer", "string":
+		d := map[string]interface{}{"type": typ}
+		for _, keyword := range []string{"const", "enum", "minimum", "maximum", "minLength", "maxLength"} {
+			if v, ok := def[keyword]; ok {
+				d[keyword] = v
+			}
+		}
+		if v, ok := def["format"]; ok {
+			name, _ := v.(string)
+			format, ok := atprotoFormats[name]
+			if !ok {
+				return nil, fmt.Errorf("format %v at %s is not supported", v, pathOrRoot(path))
+			}
+			format.apply(d)
+		}
+		return d, nil
+	case "bytes":
+		return renameKeywords(def, map[string]string{"minLength": "minSize", "maxLength": "maxSize"}, "blob"), nil
+	case "blob":
+		return atprotoBlob(def, path)
+	case "array":
+		items, ok := def["items"]
+		if !ok {
+			return nil, fmt.Errorf("array at %s is missing items", pathOrRoot(path))
+		}
+		d := renameKeywords(def, map[string]string{"minLength": "minItems", "maxLength": "maxItems"}, "array")
+		var err error
+		if d["items"], err = c.schema(items, appendPath(path, "items")); err != nil {
+			return nil, err
+		}
+		return d, nil
+	case "object":
+		return c.object(def, path)
+	case "record":
+		record, ok := def["record"].(map[string]interface{})
+		if !ok || record["type"] != "object" {
+			return nil, fmt.Errorf("record at %s must be an object schema", appendPath(path, "record"))
+		}
+		tree, err := c.object(record, appendPath(path, "record"))
+		if err != nil {
+			return nil, err
+		}
+		keys := tree["keys"].(map[string]interface{})
+		if _, ok := keys["$type"]; ok {
+			return nil, fmt.Errorf("$type at %s is reserved for the id of the record", appendPath(path, "record.properties"))
+		}
+		keys["$type?"] = map[string]interface{}{"type": "string", "const": c.id}
+		return tree, nil
+	case "ref":
+		ref, err := c.ref(def["ref"], appendPath(path, "ref"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"$ref": ref}, nil
+	case "union":
+		return c.union(def, path)
+	}
+	// unknown
+	return map[string]interface{}{"type": "tree", "additionalKeys": true}, nil
+}
+
+// apply adds the keywords checking the format to the description d of a
+// string, keeping a maxLength of d below the one of the format.
+func (f atprotoFormat) apply(d map[string]interface{}) {
+	if f.format != "" {
+		d["format"] = f.format
+		return
+	}
+	d["pattern"] = "^(" + f.pattern + ")$"
+	if f.maxLength == 0 {
+		return
+	}
+	if max, ok := d["maxLength"].(json.Number); ok {
+		if n, err := max.Int64(); err != nil || n <= f.maxLength {
+			return
+		}
+	}
+	d["maxLength"] = json.Number(strconv.FormatInt(f.maxLength, 10))
+}
+
+// object converts the AT Protocol schema of an object to a tree.
+func (c *atprotoConverter) object(def map[string]interface{}, path string) (map[string]interface{}, error) {
+	properties := map[string]interface{}{}
+	if v, ok := def["properties"]; ok {
+		if properties, ok = v.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("properties at %s must be an object", pathOrRoot(path))
+		}
+	}
+	required, err := atprotoNames(def["required"], properties, appendPath(path, "required"))
+	if err != nil {
+		return nil, err
+	}
+	nullable, err := atprotoNames(def["nullable"], properties, appendPath(path, "nullable"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{}, len(properties))
+	for _, name := range sortedSourceKeys(properties) {
+		if strings.HasSuffix(name, "?") {
+			return nil, fmt.Errorf("property %q at %s must not end with \"?\"", name, pathOrRoot(path))
+		}
+		schema, err := c.schema(properties[name], appendPath(appendPath(path, "properties"), name))
+		if err != nil {
+			return nil, err
+		}
+		if nullable[name] {
+			schema = atprotoNullable(schema)
+		}
+		key := name
+		if !required[name] {
+			key += "?"
+		}
+		keys[key] = schema
+	}
+	return map[string]interface{}{"type": "tree", "keys": keys}, nil
+}
+
+// union converts an AT Protocol union to a union discriminated by "$type".
+func (c *atprotoConverter) union(def map[string]interface{}, path string) (interface{}, error) {
+	refs, ok := def["refs"].([]interface{})
+	if !ok || len(refs) == 0 {
+		return nil, fmt.Errorf("refs at %s must be a non-empty array", pathOrRoot(path))
+	}
+	branches := make(map[string]interface{}, len(refs))
+	for i := range refs {
+		refPath := appendPath(appendPath(path, "refs"), indexSegment(i))
+		ref, err := c.ref(refs[i], refPath)
+		if err != nil {
+			return nil, err
+		}
+		name := c.typeName(refs[i].(string))
+		if _, ok := branches[name]; ok {
+			return nil, fmt.Errorf("duplicate ref %q at %s", name, refPath)
+		}
+		branches[name] = map[string]interface{}{"$ref": ref}
+	}
+	union := map[string]interface{}{
+		"oneOf":         branches,
+		"discriminator": "$type",
+	}
+	if closed, _ := def["closed"].(bool); !closed {
+		union["fallback"] = map[string]interface{}{"type": "tree", "additionalKeys": true}
+	}
+	return union, nil
+}
+
+// ref converts an AT Protocol reference, "#name", "<id>" or "<id>#name", to
+// a reference of lexicons.
+func (c *atprotoConverter) ref(value interface{}, path string) (string, error) {
+	ref, ok := value.(string)
+	if !ok || ref == "" || strings.HasSuffix(ref, "#") {
+		return "", fmt.Errorf("ref at %s must be \"#name\", \"<id>\" or \"<id>#name\"", pathOrRoot(path))
+	}
+	id, name := ref, "main"
+	if i := strings.Index(ref, "#"); i >= 0 {
+		id, name = ref[:i], ref[i+1:]
+	}
+	if id == "" || id == c.id {
+		return "#/defs/" + name, nil
+	}
+	return id + "#/defs/" + name, nil
+}
+
+// typeName returns the "$type" of values of a definition referenced with an
+// AT Protocol reference that ref accepts.
+func (c *atprotoConverter) typeName(ref string) string {
+	if strings.HasPrefix(ref, "#") {
+		ref = c.id + ref
+	}
+	return strings.TrimSuffix(ref, "#main")
+}
+
+// atprotoBlob converts an AT Protocol blob to the tree of a blob reference.
+func atprotoBlob(def map[string]interface{}, path string) (interface{}, error) {
+	mimeType := interface{}("string")
+	if v, ok := def["accept"]; ok {
+		accept, ok := v.([]interface{})
+		if !ok || len(accept) == 0 {
+			return nil, fmt.Errorf("accept at %s must be a non-empty array of MIME types", pathOrRoot(path))
+		}
+		patterns := make([]string, len(accept))
+		for i := range accept {
+			pattern, ok := accept[i].(string)
+			if !ok || strings.Count(pattern, "/") != 1 {
+				return nil, fmt.Errorf("accept at %s must be a MIME type such as \"image/*\"", appendPath(appendPath(path, "accept"), indexSegment(i)))
+			}
+			// "*" matches any type or subtype.
+			patterns[i] = strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, "[^/]+")
+		}
+		mimeType = map[string]interface{}{"type": "string", "pattern": "^(" + strings.Join(patterns, "|") + ")$"}
+	}
+	size := map[string]interface{}{"type": "integer", "minimum": json.Number("0")}
+	if v, ok := def["maxSize"]; ok {
+		size["maximum"] = v
+	}
+	return map[string]interface{}{"type": "tree", "keys": map[string]interface{}{
+		"$type":    map[string]interface{}{"type": "string", "const": "blob"},
+		"ref":      map[string]interface{}{"$link": "string"},
+		"mimeType": mimeType,
+		"size":     size,
+	}}, nil
+}
+
+// atprotoNames reads the names of properties listed in required or nullable.
+func atprotoNames(value interface{}, properties map[string]interface{}, path string) (map[string]bool, error) {
+	names := map[string]bool{}
+	if value == nil {
+		return names, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s must be an array of property names", path)
+	}
+	for i := range list {
+		name, ok := list[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s must be an array of property names", path)
+		}
+		if _, ok := properties[name]; !ok {
+			return nil, fmt.Errorf("property %q at %s is not defined", name, appendPath(path, indexSegment(i)))
+		}
+		names[name] = true
+	}
+	return names, nil
+}
+
+// atprotoNullable returns a schema that also accepts null.
+func atprotoNullable(schema interface{}) interface{} {
+	switch v := schema.(type) {
+	case string:
+		if v != "null" {
+			return v + "|null"
+		}
+		return v
+	case map[string]interface{}:
+		if typ, ok := v["type"].(string); ok {
+			v["type"] = typ + "|null"
+			return v
+		}
+	}
+	return map[string]interface{}{"anyOf": []interface{}{schema, "null"}}
+}
+
+// renameKeywords returns a description of type typ with the keywords of def
+// renamed by names.
+func renameKeywords(def map[string]interface{}, names map[string]string, typ string) map[string]interface{} {
+	d := map[string]interface{}{"type": typ}
+	for from, to := range names {
+		if v, ok := def[from]; ok {
+			d[to] = v
+		}
+	}
+	return d
+}
+
+// sortedSourceKeys returns the keys of a JSON object, sorted.
+func sortedSourceKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
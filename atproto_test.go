@@ -0,0 +1,427 @@
+package abit
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testATProtoPost = `{
+	"lexicon": 1,
+	"id": "com.example.post",
+	"description": "A post",
+	"defs": {
+		"main": {
+			"type": "record",
+			"key": "tid",
+			"record": {
+				"type": "object",
+				"required": ["text", "createdAt"],
+				"nullable": ["reply"],
+				"properties": {
+					"text": {"type": "string", "maxLength": 300, "knownValues": ["meow"]},
+					"createdAt": {"type": "string", "format": "datetime"},
+					"langs": {"type": "array", "items": {"type": "string"}, "maxLength": 3},
+					"reply": {"type": "ref", "ref": "#replyRef"},
+					"embed": {"type": "union", "refs": ["#image", "com.example.link"], "closed": true},
+					"extra": {"type": "unknown"}
+				}
+			}
+		},
+		"replyRef": {"type": "object", "required": ["uri"], "properties": {"uri": {"type": "string", "format": "uri"}}},
+		"image": {"type": "object", "required": ["data"], "properties": {"data": {"type": "blob", "accept": ["image/*"], "maxSize": 4}}},
+		"visible": {"type": "token", "description": "Shown to everyone"}
+	}
+}`
+
+func TestATProtoLexicon(t *testing.T) {
+	post, err := ParseATProtoLexicon([]byte(testATProtoPost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := ParseATProtoLexicon([]byte(`{"lexicon": 1, "id": "com.example.link", "defs": {"main": {"type": "object", "required": ["hash"], "properties": {"hash": {"type": "bytes", "minLength": 2}}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRegistry().Add(post, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("$type", "com.example.post")
+	tree.Put("text", "meow")
+	tree.Put("createdAt", "2024-05-01T12:00:00Z")
+	tree.Put("reply", Null{})
+	embed, _ := NewABITObject(&[]byte{})
+	embed.Put("$type", "com.example.post#image")
+	blob := func(mimeType string, size int64) ABITObject {
+		return testTree(map[string]interface{}{
+			"$type":    "blob",
+			"ref":      testTree(map[string]interface{}{"$link": "bafkreibme22gw2h7y2h7tg2fhqotaqjucnbc24deqo72b6mkl2egezxhvy"}),
+			"mimeType": mimeType,
+			"size":     size,
+		})
+	}
+	embed.Put("data", blob("image/png", 4))
+	tree.Put("embed", *embed)
+	extra, _ := NewABITObject(&[]byte{})
+	extra.Put("anything", int64(1))
+	tree.Put("extra", *extra)
+	if errs := post.Validate(tree); len(errs) != 0 {
+		t.Fatalf("document doesn't match: %v", errs)
+	}
+	if err := post.Compile().ValidateBytes(tree.ToByteArray()); err != nil {
+		t.Fatal(err)
+	}
+
+	embed.Put("data", blob("video/mp4", 5))
+	if errs := post.Validate(tree); len(errs) != 2 || errs[0].Path != "embed.data.size" || errs[1].Path != "embed.data.mimeType" {
+		t.Fatalf("blob reference with wrong type and size gave errors %v", errs)
+	}
+	embed.Put("data", []byte{1, 2, 3})
+	if errs := post.Validate(tree); len(errs) != 1 || errs[0].Path != "embed.data" || errs[0].Rule != RuleTypeMismatch {
+		t.Fatalf("inline blob gave errors %v", errs)
+	}
+
+	embed, _ = NewABITObject(&[]byte{})
+	embed.Put("$type", "com.example.link")
+	embed.Put("hash", []byte{1})
+	tree.Put("embed", *embed)
+	tree.Put("$type", "com.example.other")
+	tree.Remove("createdAt")
+	want := []ValidationError{
+		{Path: "$type", Rule: RuleConstraint, Constraint: "const", Expected: "string", Found: KindString, Detail: `"com.example.other" is not "com.example.post"`},
+		{Path: "embed.hash", Rule: RuleConstraint, Constraint: "minSize", Expected: "blob", Found: KindBlob, Detail: "size 1 is less than 2"},
+		{Path: "createdAt", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid},
+	}
+	if errs := post.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+
+	for doc, msg := range map[string]string{
+		`{"lexicon": 2, "id": "a", "defs": {}}`:                                                                                        "lexicon at root must be 1",
+		`{"lexicon": 1, "id": "a", "defs": {"other": {"type": "string"}}}`:                                                             "lexicon a has no main definition",
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "query"}}}`:                                                               `type "query" at defs.main is not supported`,
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "properties": {"c": {"type": "cid-link"}}}}}`:                   `type "cid-link" at defs.main.properties.c is not supported`,
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "properties": {"s": {"type": "integer", "maxGraphemes": 3}}}}}`: `maxGraphemes at defs.main.properties.s is not supported for type "integer"`,
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "properties": {"s": {"type": "string", "format": "email"}}}}}`:  `format email at defs.main.properties.s is not supported`,
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "required": ["x"], "properties": {}}}}`:                         `property "x" at defs.main.required[0] is not defined`,
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object"}, "r": {"type": "record", "record": {"type": "object"}}}}`:       "record at defs.r must be the main definition",
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "properties": {"b": {"type": "blob", "accept": "image/*"}}}}}`:  "accept at defs.main.properties.b must be a non-empty array of MIME types",
+		`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "properties": {"b": {"type": "blob", "accept": ["image"]}}}}}`:  `accept at defs.main.properties.b.accept[0] must be a MIME type such as "image/*"`,
+	} {
+		_, err := ParseATProtoLexicon([]byte(doc))
+		if err == nil || err.Error() != msg {
+			t.Fatalf("lexicon %s gave error %v, want %s", doc, err, msg)
+		}
+	}
+}
+
+// The lexicons below are copies of lexicons published by Bluesky.
+const (
+	testBskyFeedPost = `{
+	"lexicon": 1,
+	"id": "app.bsky.feed.post",
+	"defs": {
+		"main": {
+			"type": "record",
+			"description": "Record containing a Bluesky post.",
+			"key": "tid",
+			"record": {
+				"type": "object",
+				"required": ["text", "createdAt"],
+				"properties": {
+					"text": {
+						"type": "string",
+						"maxLength": 3000,
+						"maxGraphemes": 300,
+						"description": "The primary post content. May be an empty string, if there are embeds."
+					},
+					"entities": {
+						"type": "array",
+						"description": "DEPRECATED: replaced by app.bsky.richtext.facet.",
+						"items": {"type": "ref", "ref": "#entity"}
+					},
+					"facets": {
+						"type": "array",
+						"description": "Annotations of text (mentions, URLs, hashtags, etc)",
+						"items": {"type": "ref", "ref": "app.bsky.richtext.facet"}
+					},
+					"reply": {"type": "ref", "ref": "#replyRef"},
+					"embed": {
+						"type": "union",
+						"refs": [
+							"app.bsky.embed.images",
+							"app.bsky.embed.video",
+							"app.bsky.embed.external",
+							"app.bsky.embed.record",
+							"app.bsky.embed.recordWithMedia"
+						]
+					},
+					"langs": {
+						"type": "array",
+						"description": "Indicates human language of post primary text content.",
+						"maxLength": 3,
+						"items": {"type": "string", "format": "language"}
+					},
+					"labels": {
+						"type": "union",
+						"description": "Self-label values for this post. Effectively content warnings.",
+						"refs": ["com.atproto.label.defs#selfLabels"]
+					},
+					"tags": {
+						"type": "array",
+						"description": "Additional hashtags, in addition to any included in post text and facets.",
+						"maxLength": 8,
+						"items": {"type": "string", "maxLength": 640, "maxGraphemes": 64}
+					},
+					"createdAt": {
+						"type": "string",
+						"format": "datetime",
+						"description": "Client-declared timestamp when this post was originally created."
+					}
+				}
+			}
+		},
+		"replyRef": {
+			"type": "object",
+			"required": ["root", "parent"],
+			"properties": {
+				"root": {"type": "ref", "ref": "com.atproto.repo.strongRef"},
+				"parent": {"type": "ref", "ref": "com.atproto.repo.strongRef"}
+			}
+		},
+		"entity": {
+			"type": "object",
+			"description": "Deprecated: use facets instead.",
+			"required": ["index", "type", "value"],
+			"properties": {
+				"index": {"type": "ref", "ref": "#textSlice"},
+				"type": {"type": "string", "description": "Expected values are 'mention' and 'link'."},
+				"value": {"type": "string"}
+			}
+		},
+		"textSlice": {
+			"type": "object",
+			"description": "Deprecated. Use app.bsky.richtext instead -- A text segment. Start is inclusive, end is exclusive. Indices are for utf16-encoded strings.",
+			"required": ["start", "end"],
+			"properties": {
+				"start": {"type": "integer", "minimum": 0},
+				"end": {"type": "integer", "minimum": 0}
+			}
+		}
+	}
+}`
+
+	testBskyRichtextFacet = `{
+	"lexicon": 1,
+	"id": "app.bsky.richtext.facet",
+	"defs": {
+		"main": {
+			"type": "object",
+			"description": "Annotation of a sub-string within rich text.",
+			"required": ["index", "features"],
+			"properties": {
+				"index": {"type": "ref", "ref": "#byteSlice"},
+				"features": {
+					"type": "array",
+					"items": {"type": "union", "refs": ["#mention", "#link", "#tag"]}
+				}
+			}
+		},
+		"mention": {
+			"type": "object",
+			"description": "Facet feature for mention of another account. The text is usually a handle, including a '@' prefix, but the facet reference is a DID.",
+			"required": ["did"],
+			"properties": {
+				"did": {"type": "string", "format": "did"}
+			}
+		},
+		"link": {
+			"type": "object",
+			"description": "Facet feature for a URL. The text URL may have been simplified or truncated, but the facet reference should be a complete URL.",
+			"required": ["uri"],
+			"properties": {
+				"uri": {"type": "string", "format": "uri"}
+			}
+		},
+		"tag": {
+			"type": "object",
+			"description": "Facet feature for a hashtag. The text usually includes a '#' prefix, but the facet reference should not (except in the case of 'double hash tags').",
+			"required": ["tag"],
+			"properties": {
+				"tag": {"type": "string", "maxLength": 640, "maxGraphemes": 64}
+			}
+		},
+		"byteSlice": {
+			"type": "object",
+			"description": "Specifies the sub-string range a facet feature applies to. Start index is inclusive, end index is exclusive. Indices are zero-indexed, counting bytes of the UTF-8 encoded text.",
+			"required": ["byteStart", "byteEnd"],
+			"properties": {
+				"byteStart": {"type": "integer", "minimum": 0},
+				"byteEnd": {"type": "integer", "minimum": 0}
+			}
+		}
+	}
+}`
+
+	testATProtoStrongRef = `{
+	"lexicon": 1,
+	"id": "com.atproto.repo.strongRef",
+	"description": "A URI with a content-hash fingerprint.",
+	"defs": {
+		"main": {
+			"type": "object",
+			"required": ["uri", "cid"],
+			"properties": {
+				"uri": {"type": "string", "format": "at-uri"},
+				"cid": {"type": "string", "format": "cid"}
+			}
+		}
+	}
+}`
+)
+
+func TestATProtoBluesky(t *testing.T) {
+	post, err := ParseATProtoLexicon([]byte(testBskyFeedPost))
+	if err != nil {
+		t.Fatal(err)
+	}
+	array := func(values ...interface{}) ABITArray {
+		arr := NewABITArray()
+		for _, v := range values {
+			arr.Add(v)
+		}
+		return *arr
+	}
+	record := func(edit map[string]interface{}) *ABITObject {
+		tree := testTree(map[string]interface{}{
+			"$type":     "app.bsky.feed.post",
+			"text":      "meow #abit",
+			"createdAt": "2024-05-01T12:00:00.000Z",
+			"langs":     array("sv", "en-US"),
+			"tags":      array("abit"),
+			// Open unions accept trees of any other "$type".
+			"embed":    testTree(map[string]interface{}{"$type": "com.example.embed", "anything": int64(1)}),
+			"entities": array(testTree(map[string]interface{}{"index": testTree(map[string]interface{}{"start": int64(0), "end": int64(4)}), "type": "link", "value": "meow"})),
+		})
+		for key, value := range edit {
+			if value == nil {
+				tree.Remove(key)
+			} else {
+				tree.Put(key, value)
+			}
+		}
+		return &tree
+	}
+	validator := post.Compile()
+	for i, c := range []struct {
+		edit map[string]interface{}
+		path string
+	}{
+		{nil, ""},
+		{map[string]interface{}{"text": strings.Repeat("ö", 1500)}, ""},
+		{map[string]interface{}{"text": strings.Repeat("ö", 1501)}, "text"},
+		{map[string]interface{}{"langs": array("sv", "Swedish")}, "langs[1]"},
+		{map[string]interface{}{"langs": array("sv", "en", "de", "fr")}, "langs"},
+		{map[string]interface{}{"embed": testTree(map[string]interface{}{"anything": int64(1)})}, "embed.$type"},
+		{map[string]interface{}{"createdAt": nil}, "createdAt"},
+	} {
+		doc := record(c.edit)
+		errs := post.Validate(doc)
+		err := validator.ValidateBytes(doc.ToByteArray())
+		if c.path == "" {
+			if len(errs) != 0 || err != nil {
+				t.Fatalf("case %d: valid post gave errors %v and %v", i, errs, err)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].Path != c.path || err != errs[0] {
+			t.Fatalf("case %d: expected an error at %s, got %v and %v", i, c.path, errs, err)
+		}
+	}
+
+	facet, err := ParseATProtoLexicon([]byte(testBskyRichtextFacet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	strongRef, err := ParseATProtoLexicon([]byte(testATProtoStrongRef))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewRegistry().Add(facet, strongRef); err != nil {
+		t.Fatal(err)
+	}
+	feature := func(name string, key string, value interface{}) ABITObject {
+		return testTree(map[string]interface{}{"$type": name, key: value})
+	}
+	annotation := testTree(map[string]interface{}{
+		"index": testTree(map[string]interface{}{"byteStart": int64(0), "byteEnd": int64(4)}),
+		"features": array(
+			feature("app.bsky.richtext.facet#mention", "did", "did:plc:z72i7hdynmk6r22z27h6tvur"),
+			feature("app.bsky.richtext.facet#link", "uri", "https://bsky.app"),
+			feature("app.bsky.richtext.facet#tag", "tag", "abit"),
+			feature("com.example.feature", "anything", true),
+		),
+	})
+	if errs := facet.Validate(&annotation); len(errs) != 0 {
+		t.Fatalf("facet doesn't match: %v", errs)
+	}
+	annotation.Put("features", array(feature("app.bsky.richtext.facet#mention", "did", "@alice.bsky.social")))
+	if errs := facet.Validate(&annotation); len(errs) != 1 || errs[0].Path != "features[0].did" || errs[0].Constraint != "pattern" {
+		t.Fatalf("mention of a handle gave errors %v", errs)
+	}
+
+	for uri, valid := range map[string]bool{
+		"at://did:plc:z72i7hdynmk6r22z27h6tvur/app.bsky.feed.post/3k2yihcrp6f2c": true,
+		"at://alice.bsky.social/app.bsky.feed.post":                              true,
+		"at://alice.bsky.social":                                                 true,
+		"at://alice.bsky.social/app.bsky.feed.post/.":                            false,
+		"at://alice/app.bsky.feed.post":                                          false,
+		"https://bsky.app/profile/alice.bsky.social":                             false,
+	} {
+		ref := testTree(map[string]interface{}{"uri": uri, "cid": "bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a"})
+		if errs := strongRef.Validate(&ref); (len(errs) == 0) != valid {
+			t.Fatalf("at-uri %s gave errors %v", uri, errs)
+		}
+	}
+}
+
+func TestATProtoFormats(t *testing.T) {
+	for format, values := range map[string]map[string]bool{
+		"did":           {"did:plc:z72i7hdynmk6r22z27h6tvur": true, "did:web:example.com": true, "did:plc:": false, "DID:plc:abc": false},
+		"handle":        {"alice.bsky.social": true, "xn--ls8h.test": true, "alice": false, "-alice.bsky.social": false, "alice.bsky.123": false},
+		"at-identifier": {"alice.bsky.social": true, "did:web:example.com": true, "@alice.bsky.social": false},
+		"nsid":          {"app.bsky.feed.post": true, "com.example.fooBar": true, "com.example": false, "com.example.foo-bar": false},
+		"cid":           {"bafyreidfayvfuwqa7qlnopdjiqrxzs6blmoeu4rujcjtnci5beludirz2a": true, "bafy": false, "bafy reidfayvfuwqa": false},
+		"tid":           {"3jzfcijpj2z2a": true, "3jzfcijpj2z2": false, "kjzfcijpj2z2a": false},
+		"record-key":    {"self": true, "3jzfcijpj2z2a": true, "..a": true, ".a": true, ".": false, "..": false, "a/b": false},
+		"language":      {"en": true, "pt-BR": true, "i-klingon": true, "english": false, "EN": false},
+	} {
+		lexicon := `{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "required": ["s"], "properties": {"s": {"type": "string", "format": "` + format + `"}}}}}`
+		lex, err := ParseATProtoLexicon([]byte(lexicon))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for value, valid := range values {
+			tree := testTree(map[string]interface{}{"s": value})
+			if errs := lex.Validate(&tree); (len(errs) == 0) != valid {
+				t.Fatalf("%s %q gave errors %v", format, value, errs)
+			}
+		}
+	}
+
+	// The maximum length of a format applies unless the lexicon sets a lower one.
+	lex, err := ParseATProtoLexicon([]byte(`{"lexicon": 1, "id": "a", "defs": {"main": {"type": "object", "required": ["s", "t"], "properties": {
+		"s": {"type": "string", "format": "handle"},
+		"t": {"type": "string", "format": "handle", "maxLength": 10}
+	}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := testTree(map[string]interface{}{"s": strings.Repeat("a.", 127) + "com", "t": "bsky.social"})
+	errs := lex.Validate(&tree)
+	if len(errs) != 2 || errs[0].Constraint != "maxLength" || errs[1].Constraint != "maxLength" {
+		t.Fatalf("handles longer than the maximum length gave errors %v", errs)
+	}
+}
@@ -0,0 +1,566 @@
+package abit
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompiledLexicon checks encoded documents against a lexicon without decoding
+// them into ABITObjects. It is created with ABITLexicon.Compile.
+type CompiledLexicon struct {
+	root *compiledNode
+}
+
+// compiledNode is a lexNode prepared for checking encoded values, with its
+// references resolved.
+type compiledNode struct {
+	// node is the schema, or a reference that was not resolved.
+	node   *lexNode
+	fields map[string]compiledField
+	// required are the keys in fields that are not optional, in canonical
+	// order.
+	required   []string
+	tuple      []*compiledNode
+	items      *compiledNode
+	additional *compiledNode
+	branches   []*compiledNode
+	fallback   *compiledNode
+}
+
+type compiledField struct {
+	node     *compiledNode
+	optional bool
+}
+
+// Compile prepares the lexicon for checking encoded documents with
+// ValidateBytes. References to other lexicons are resolved as they are when
+// Compile is called, so compile lexicons after adding them to a Registry.
+//
+// # Example
+//
+//	validator := lex.Compile()
+//	if err := validator.ValidateBytes(payload); err != nil {
+//		// Reject the payload here
+//	}
+func (l *ABITLexicon) Compile() *CompiledLexicon {
+	return &CompiledLexicon{
+		root: compileNode(l.rootNode(), map[*lexNode]*compiledNode{}),
+	}
+}
+
+func compileNode(n *lexNode, compiled map[*lexNode]*compiledNode) *compiledNode {
+	n = n.resolved()
+	if c, ok := compiled[n]; ok {
+		return c
+	}
+	c := &compiledNode{node: n}
+	compiled[n] = c
+	if n.fields != nil {
+		c.fields = make(map[string]compiledField, len(n.fields))
+		for key, field := range n.fields {
+			c.fields[key] = compiledField{
+				node:     compileNode(field.node, compiled),
+				optional: field.optional,
+			}
+		}
+		for _, key := range sortedFieldKeys(n.fields) {
+			if !n.fields[key].optional {
+				c.required = append(c.required, key)
+			}
+		}
+	}
+	for _, item := range n.tuple {
+		c.tuple = append(c.tuple, compileNode(item, compiled))
+	}
+	if n.items != nil {
+		c.items = compileNode(n.items, compiled)
+	}
+	if n.additional != nil {
+		c.additional = compileNode(n.additional, compiled)
+	}
+	if n.union != nil {
+		for _, branch := range n.union.branches {
+			c.branches = append(c.branches, compileNode(branch, compiled))
+		}
+		if n.union.fallback != nil {
+			c.fallback = compileNode(n.union.fallback, compiled)
+		}
+	}
+	return c
+}
+
+// ValidateBytes checks that doc is an ABIT document matching the lexicon. The
+// document is read once, without decoding it into an ABITObject.
+//
+// error is nil if the document matches. Otherwise it is a *DecodeError if
+// the document is not valid ABIT, or a ValidationError for the first
+// violation found.
+//
+// Values matched by "any" are skipped by their length prefix, so invalid
+// ABIT inside them is not reported. When no branch of a union matches, the
+// error of the first branch accepting the type of the value is reported.
+// Validate reports the branch with the fewest errors instead, which needs
+// every error of every branch, so the two may report different branches.
+//
+// Trees and arrays are checked recursively, so ValidateBytes stops at a
+// depth of ValidateBytesMaxDepth with a *DecodeError caused by a
+// *LimitError. Use ValidateBytesWithOptions to choose other limits.
+func (c *CompiledLexicon) ValidateBytes(doc []byte) error {
+	return c.ValidateBytesWithOptions(doc, DecodeOptions{MaxDepth: ValidateBytesMaxDepth})
+}
+
+// ValidateBytesMaxDepth is the MaxDepth used by ValidateBytes.
+const ValidateBytesMaxDepth = 10000
+
+// ValidateBytesWithOptions is like ValidateBytes, reading doc with the limits
+// in opts instead. Values skipped because they match "any" are not counted
+// towards the limits, and Strict is ignored. A MaxDepth of 0 means no limit,
+// so set it for documents from untrusted sources.
+//
+// # Example
+//
+//	err := validator.ValidateBytesWithOptions(payload, abit.DecodeOptions{
+//		MaxDepth:        64,
+//		MaxDocumentSize: 1 << 20,
+//	})
+func (c *CompiledLexicon) ValidateBytesWithOptions(doc []byte, opts DecodeOptions) error {
+	s := decodeState{opts: opts}
+	if err := s.checkDocumentSize(int64(len(doc))); err != nil {
+		return err
+	}
+	root := View{
+		kind: KindTree,
+		raw:  doc,
+		body: doc,
+	}
+	return c.root.check(&s, &doc, root, "")
+}
+
+// check checks the value v read from doc, with offsets of v relative to the
+// start of doc. skip is a key of trees that is left out, the discriminator
+// of the union the node is a branch of. s counts the values nested in v.
+func (c *compiledNode) check(s *decodeState, doc *[]byte, v View, skip string) error {
+	node := c.node
+	if node.ref != nil {
+		return ValidationError{
+			Rule:       RuleConstraint,
+			Constraint: "$ref",
+			Found:      v.kind,
+			Detail:     fmt.Sprintf("reference %s is not resolved", node.ref),
+		}
+	}
+	if c.branches != nil {
+		return c.union(s, doc, v, skip)
+	}
+	if node.any {
+		return nil
+	}
+	if !node.accepts(v.kind) {
+		return ValidationError{
+			Rule:     RuleTypeMismatch,
+			Expected: kindsString(node.kinds),
+			Found:    v.kind,
+		}
+	}
+
+	var ok bool
+	switch v.kind {
+	case KindBoolean:
+		ok = node.enum == nil || enumHasBool(node.enum, v.boolean)
+	case KindInteger:
+		ok = inBounds(v.integer, node.minimum, node.maximum) && (node.enum == nil || enumHasInteger(node.enum, v.integer))
+	case KindBlob:
+		ok = inBounds(int64(len(v.body)), node.minSize, node.maxSize)
+	case KindString:
+		ok = checkStringBytes(node, v.body)
+	case KindArray:
+		return c.array(s, doc, v)
+	case KindTree:
+		return c.tree(s, doc, v, skip)
+	default:
+		ok = true
+	}
+	if ok {
+		return nil
+	}
+	return describeViolation(node, v)
+}
+
+// checkNested checks the tree or array value starting at offset as a value
+// nested one level deeper than the value containing it.
+func (c *compiledNode) checkNested(s *decodeState, doc *[]byte, offset int64, value View) error {
+	if value.kind != KindTree && value.kind != KindArray {
+		return c.check(s, doc, value, "")
+	}
+	if err := s.enter(offset); err != nil {
+		return err
+	}
+	err := c.check(s, doc, value, "")
+	s.leave()
+	return err
+}
+
+// countValue counts the value v starting at offset against the limits of s.
+func (s *decodeState) countValue(offset int64, v View) error {
+	if err := s.countElement(offset); err != nil {
+		return err
+	}
+	if v.kind == KindBlob || v.kind == KindString {
+		return s.checkBlobSize(offset, int64(len(v.body)))
+	}
+	return nil
+}
+
+// describeViolation returns the first violation of a boolean, integer, blob
+// or string in the same way as Validate.
+func describeViolation(node *lexNode, v View) error {
+	obj := &ABITObject{
+		dataType: uint8(v.kind),
+		boolean:  v.boolean,
+		integer:  v.integer,
+	}
+	switch v.kind {
+	case KindBlob:
+		obj.blob = &v.body
+	case KindString:
+		text := string(v.body)
+		obj.text = &text
+	}
+	val := &validator{}
+	val.node(node, obj, "")
+	return val.errs[0]
+}
+
+// inBounds reports whether n is within the bounds that are set.
+func inBounds(n int64, min *int64, max *int64) bool {
+	return (min == nil || n >= *min) && (max == nil || n <= *max)
+}
+
+func enumHasBool(enum []interface{}, b bool) bool {
+	for _, value := range enum {
+		if allowed, ok := value.(bool); ok && allowed == b {
+			return true
+		}
+	}
+	return false
+}
+
+func enumHasInteger(enum []interface{}, n int64) bool {
+	for _, value := range enum {
+		if allowed, ok := value.(int64); ok && allowed == n {
+			return true
+		}
+	}
+	return false
+}
+
+// enumHasString reports whether the encoded string s is in enum.
+func enumHasString(enum []interface{}, s []byte) bool {
+	for _, value := range enum {
+		if allowed, ok := value.(string); ok && allowed == string(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStringBytes reports whether the encoded string s follows the string
+// constraints of node.
+func checkStringBytes(node *lexNode, s []byte) bool {
+	if node.enum != nil && !enumHasString(node.enum, s) {
+		return false
+	}
+	if !inBounds(int64(len(s)), node.minLength, node.maxLength) {
+		return false
+	}
+	if node.pattern != nil && !node.pattern.Match(s) {
+		return false
+	}
+	return node.format == "" || lexFormats[node.format](string(s))
+}
+
+// keyCompareBytes is keyCompare for keys in encoded documents.
+func keyCompareBytes(a, b []byte) bool {
+	if len(a) == len(b) {
+		return bytes.Compare(a, b) < 0
+	}
+	return len(a) < len(b)
+}
+
+// keyCompareString reports whether the key a sorts before the key b in
+// canonical order.
+func keyCompareString(a string, b []byte) bool {
+	if len(a) == len(b) {
+		return a < string(b)
+	}
+	return len(a) < len(b)
+}
+
+// prependPath prepends segment to the path of a ValidationError or
+// DecodeError returned for a nested value.
+func prependPath(err error, segment string) error {
+	if validationErr, ok := err.(ValidationError); ok {
+		validationErr.Path = appendPath(segment, validationErr.Path)
+		return validationErr
+	}
+	return locateDecodeError(err, 0, segment)
+}
+
+func (c *compiledNode) tree(s *decodeState, doc *[]byte, v View, skip string) error {
+	if c.node.extends != nil && c.fields == nil {
+		return unresolvedParents("")
+	}
+	end := v.base + int64(len(v.body))
+	index := v.base
+	// Keys are in canonical order like required, so the required keys are
+	// found by walking both at once. found is the number of required keys
+	// walked and missing the index of the first one not in the tree.
+	found, missing := 0, -1
+	keys := 0
+	var lastKey []byte
+	for index < end {
+		start := index
+		keys++
+		if err := s.checkKeys(start, keys); err != nil {
+			return err
+		}
+		key, next, err := decodeKeyBytes(doc, index)
+		if err != nil {
+			return err
+		}
+		if !keyCompareBytes(lastKey, key) {
+			err := newDecodeError(start, ErrKeyOrder)
+			err.Path = string(key)
+			return err
+		}
+		lastKey = key
+		value, valueEnd, err := viewAt(doc, next, 0)
+		if err != nil {
+			return locateDecodeError(err, 0, string(key))
+		}
+		if valueEnd > end {
+			err := newDecodeError(start, ErrOverrun)
+			err.Path = string(key)
+			return err
+		}
+		index = valueEnd
+		if err := s.countValue(next, value); err != nil {
+			return locateDecodeError(err, 0, string(key))
+		}
+
+		if string(key) == skip {
+			continue
+		}
+		for found < len(c.required) && keyCompareString(c.required[found], key) {
+			if missing < 0 {
+				missing = found
+			}
+			found++
+		}
+		if found < len(c.required) && c.required[found] == string(key) {
+			found++
+		}
+		if field, ok := c.fields[string(key)]; ok {
+			err = field.node.checkNested(s, doc, next, value)
+		} else if c.additional != nil {
+			node := c.node
+			if !inBounds(int64(len(key)), node.minKeyLength, node.maxKeyLength) || (node.keyPattern != nil && !node.keyPattern.Match(key)) {
+				val := &validator{}
+				val.key(node, string(key), "")
+				err = val.errs[0]
+			} else {
+				err = c.additional.checkNested(s, doc, next, value)
+			}
+		} else {
+			err = ValidationError{
+				Rule:  RuleUnexpectedKey,
+				Found: value.kind,
+			}
+		}
+		if err != nil {
+			return prependPath(err, string(key))
+		}
+	}
+	if missing < 0 && found < len(c.required) {
+		missing = found
+	}
+	if missing >= 0 {
+		key := c.required[missing]
+		return ValidationError{
+			Path:     key,
+			Rule:     RuleMissingKey,
+			Expected: kindsString(c.node.fields[key].node.kindSet()),
+			Found:    KindInvalid,
+		}
+	}
+	return nil
+}
+
+func (c *compiledNode) array(s *decodeState, doc *[]byte, v View) error {
+	end := v.base + int64(len(v.body))
+	// Values can not reach past the end of the array.
+	body := (*doc)[:end]
+	count := 0
+	for index := v.base; index < end; count++ {
+		value, next, err := viewAt(&body, index, 0)
+		if err != nil {
+			return locateDecodeError(err, 0, indexSegment(count))
+		}
+		if err := s.countValue(index, value); err != nil {
+			return locateDecodeError(err, 0, indexSegment(count))
+		}
+		start := index
+		index = next
+		item := c.items
+		if item == nil && count < len(c.tuple) {
+			item = c.tuple[count]
+		}
+		if item == nil {
+			continue
+		}
+		if err := item.checkNested(s, &body, start, value); err != nil {
+			return prependPath(err, indexSegment(count))
+		}
+	}
+
+	node := c.node
+	val := &validator{}
+	if c.items != nil {
+		val.bounds(node, KindArray, "", "count", int64(count), node.minItems, "minItems", node.maxItems, "maxItems")
+	} else if count != len(c.tuple) {
+		val.constraint(node, KindArray, "", "length", "tuple has %d values, expected %d", count, len(c.tuple))
+	}
+	if len(val.errs) > 0 {
+		return val.errs[0]
+	}
+	return nil
+}
+
+func (c *compiledNode) union(s *decodeState, doc *[]byte, v View, skip string) error {
+	union := c.node.union
+	if union.discriminator != "" {
+		return c.discriminated(s, doc, v, skip)
+	}
+
+	// first is the index of the first matching branch, matches the indexes
+	// of all matching branches once a second one matches.
+	first := -1
+	var matches []string
+	var closest error
+	// Every branch reads the values nested in v again, so they are counted
+	// once, as many times as the branch reading the most of them did.
+	elements, most := s.elements, s.elements
+	defer func() { s.elements = most }()
+	for i, branch := range c.branches {
+		s.elements = elements
+		err := branch.check(s, doc, v, skip)
+		if s.elements > most {
+			most = s.elements
+		}
+		if err == nil {
+			if !union.exclusive {
+				return nil
+			}
+			if first < 0 {
+				first = i
+			} else {
+				if matches == nil {
+					matches = []string{strconv.Itoa(first)}
+				}
+				matches = append(matches, strconv.Itoa(i))
+			}
+			continue
+		}
+		validationErr, ok := err.(ValidationError)
+		if !ok {
+			return err
+		}
+		wrongKind := validationErr.Rule == RuleTypeMismatch && validationErr.Path == ""
+		if closest == nil && !wrongKind {
+			closest = err
+		}
+	}
+	switch {
+	case matches != nil:
+		return ValidationError{
+			Rule:       RuleConstraint,
+			Constraint: "oneOf",
+			Expected:   kindsString(c.node.kindSet()),
+			Found:      v.kind,
+			Detail:     fmt.Sprintf("value matches branches %s", strings.Join(matches, ", ")),
+		}
+	case first >= 0:
+		return nil
+	case closest == nil:
+		return ValidationError{
+			Rule:     RuleTypeMismatch,
+			Expected: kindsString(c.node.kindSet()),
+			Found:    v.kind,
+		}
+	}
+	return closest
+}
+
+func (c *compiledNode) discriminated(s *decodeState, doc *[]byte, v View, skip string) error {
+	union := c.node.union
+	if v.kind != KindTree {
+		return ValidationError{
+			Rule:     RuleTypeMismatch,
+			Expected: KindTree.String(),
+			Found:    v.kind,
+		}
+	}
+
+	var discriminator View
+	found := false
+	end := v.base + int64(len(v.body))
+	for index := v.base; index < end && !found; {
+		key, next, err := decodeKeyBytes(doc, index)
+		if err != nil {
+			return err
+		}
+		value, valueEnd, err := viewAt(doc, next, 0)
+		if err != nil {
+			return locateDecodeError(err, 0, string(key))
+		}
+		index = valueEnd
+		if string(key) == union.discriminator && string(key) != skip {
+			discriminator = value
+			found = true
+		}
+	}
+	if !found {
+		return ValidationError{
+			Path:     union.discriminator,
+			Rule:     RuleMissingKey,
+			Expected: KindString.String(),
+			Found:    KindInvalid,
+		}
+	}
+	if discriminator.kind != KindString {
+		return ValidationError{
+			Path:     union.discriminator,
+			Rule:     RuleTypeMismatch,
+			Expected: KindString.String(),
+			Found:    discriminator.kind,
+		}
+	}
+	for i, name := range union.names {
+		if name == string(discriminator.body) {
+			return c.branches[i].check(s, doc, v, union.discriminator)
+		}
+	}
+	if c.fallback != nil {
+		return c.fallback.check(s, doc, v, union.discriminator)
+	}
+	return ValidationError{
+		Path:       union.discriminator,
+		Rule:       RuleConstraint,
+		Constraint: "discriminator",
+		Expected:   KindString.String(),
+		Found:      KindString,
+		Detail:     fmt.Sprintf("%q is not one of %s", string(discriminator.body), quoteAll(union.names)),
+	}
+}
@@ -0,0 +1,210 @@
+package abit
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValidateBytes(t *testing.T) {
+	reg := NewRegistry()
+	lex := parseTestLexicon(t, `{
+		"$lexicon": 1,
+		"id": "com.example.order",
+		"defs": {
+			"main": {
+				"id": {"type": "integer", "minimum": 1},
+				"status": {"type": "string", "enum": ["open", "paid"]},
+				"items": {"type": "array", "items": {"$ref": "#/defs/item"}, "maxItems": 3},
+				"pair?": ["integer", {"type": "blob", "maxSize": 2}],
+				"labels?": {"type": "map", "values": "string", "keyPattern": "^[a-z]+$"},
+				"meta?": "any",
+				"embed?": {"oneOf": {"note": {"text": "string"}, "item": {"$ref": "#/defs/item"}}, "discriminator": "$type"},
+				"open?": {"oneOf": {"item": {"$ref": "#/defs/item"}}, "discriminator": "$type", "fallback": {"text": "string"}},
+				"value?": {"oneOf": ["boolean", {"type": "string", "format": "uri"}, {"type": "string", "maxLength": 2}]},
+				"choice?": {"oneOf": [{"a": "integer", "b": "integer"}, {"a": "string", "b": "string"}]}
+			},
+			"item": {"price": {"type": "integer", "minimum": 0}, "note?": "string|null"}
+		}
+	}`)
+	if err := reg.Add(lex); err != nil {
+		t.Fatal(err)
+	}
+	validator := lex.Compile()
+
+	array := func(values ...interface{}) ABITArray {
+		arr := NewABITArray()
+		for _, v := range values {
+			arr.Add(v)
+		}
+		return *arr
+	}
+	item := func(price int64) ABITObject {
+		return testTree(map[string]interface{}{"price": price})
+	}
+	base := func(edit map[string]interface{}) *ABITObject {
+		doc := testTree(map[string]interface{}{
+			"id":     int64(7),
+			"status": "open",
+			"items":  array(item(1), item(2)),
+		})
+		for key, value := range edit {
+			if value == nil {
+				doc.Remove(key)
+			} else {
+				doc.Put(key, value)
+			}
+		}
+		return &doc
+	}
+
+	docs := []*ABITObject{
+		base(nil),
+		base(map[string]interface{}{"id": int64(0)}),
+		base(map[string]interface{}{"id": "7"}),
+		base(map[string]interface{}{"status": "closed"}),
+		base(map[string]interface{}{"status": nil}),
+		base(map[string]interface{}{"extra": Null{}}),
+		base(map[string]interface{}{"items": array()}),
+		base(map[string]interface{}{"items": array(item(1), item(1), item(1), item(1))}),
+		base(map[string]interface{}{"items": array(item(1), item(-1))}),
+		base(map[string]interface{}{"items": array(item(1), testTree(map[string]interface{}{"price": int64(1), "note": Null{}}))}),
+		base(map[string]interface{}{"items": array(item(1), testTree(map[string]interface{}{"note": "a"}))}),
+		base(map[string]interface{}{"pair": array(int64(1), []byte{1, 2})}),
+		base(map[string]interface{}{"pair": array(int64(1), []byte{1, 2, 3})}),
+		base(map[string]interface{}{"pair": array(int64(1))}),
+		base(map[string]interface{}{"pair": array(int64(1), []byte{}, int64(3))}),
+		base(map[string]interface{}{"labels": testTree(map[string]interface{}{"a": "b"})}),
+		base(map[string]interface{}{"labels": testTree(map[string]interface{}{"A": "b"})}),
+		base(map[string]interface{}{"labels": testTree(map[string]interface{}{"a": int64(1)})}),
+		base(map[string]interface{}{"meta": testTree(map[string]interface{}{"x": array(Null{}, true)})}),
+		base(map[string]interface{}{"embed": testTree(map[string]interface{}{"$type": "note", "text": "a"})}),
+		base(map[string]interface{}{"embed": testTree(map[string]interface{}{"$type": "item", "price": int64(-1)})}),
+		base(map[string]interface{}{"embed": testTree(map[string]interface{}{"$type": "link"})}),
+		base(map[string]interface{}{"embed": testTree(map[string]interface{}{"text": "a"})}),
+		base(map[string]interface{}{"embed": testTree(map[string]interface{}{"$type": true})}),
+		base(map[string]interface{}{"open": testTree(map[string]interface{}{"$type": "item", "price": int64(1)})}),
+		base(map[string]interface{}{"open": testTree(map[string]interface{}{"$type": "note", "text": "a"})}),
+		base(map[string]interface{}{"open": testTree(map[string]interface{}{"$type": "note", "price": int64(1)})}),
+		base(map[string]interface{}{"value": true}),
+		base(map[string]interface{}{"value": "ab"}),
+		base(map[string]interface{}{"value": "https://a"}),
+		base(map[string]interface{}{"value": "abc"}),
+		base(map[string]interface{}{"value": int64(1)}),
+		base(map[string]interface{}{"choice": testTree(map[string]interface{}{"a": int64(1), "b": int64(2)})}),
+		base(map[string]interface{}{"choice": testTree(map[string]interface{}{"a": int64(1), "b": "y"})}),
+	}
+	for i, doc := range docs {
+		errs := lex.Validate(doc)
+		err := validator.ValidateBytes(doc.ToByteArray())
+		if (err == nil) != (len(errs) == 0) {
+			t.Fatalf("case %d: ValidateBytes returned %v, Validate %v", i, err, errs)
+		}
+		if err == nil {
+			continue
+		}
+		found := false
+		for _, e := range errs {
+			found = found || e == err
+		}
+		if !found {
+			t.Fatalf("case %d: ValidateBytes returned %#v, not one of %v", i, err, errs)
+		}
+	}
+
+	// The first branch has two errors, the second one. Validate reports the
+	// second branch and ValidateBytes the first error of the first.
+	closest := base(map[string]interface{}{"choice": testTree(map[string]interface{}{"a": "x", "b": true})})
+	errs := lex.Validate(closest)
+	if len(errs) != 1 || errs[0].Path != "choice.b" {
+		t.Fatalf("Validate returned %v, expected the error of the second branch", errs)
+	}
+	if err, ok := validator.ValidateBytes(closest.ToByteArray()).(ValidationError); !ok || err.Path != "choice.a" {
+		t.Fatalf("ValidateBytes returned %v, expected the first error of the first branch", err)
+	}
+
+	valid := base(nil).ToByteArray()
+	for _, invalid := range [][]byte{
+		valid[:len(valid)-1],
+		append(append([]byte{}, valid...), 0),
+	} {
+		var decodeErr *DecodeError
+		if err := validator.ValidateBytes(invalid); !errors.As(err, &decodeErr) {
+			t.Fatalf("invalid document % x returned %v", invalid, err)
+		}
+	}
+
+	pair := parseTestLexicon(t, `{"a?": "null", "b?": "null"}`).Compile()
+	if err := pair.ValidateBytes(nil); err != nil {
+		t.Fatalf("empty document returned %v", err)
+	}
+	if err := pair.ValidateBytes([]byte{0x00, 'b', 0x00, 0x00, 'a', 0x00}); !errors.Is(err, ErrKeyOrder) {
+		t.Fatalf("unordered keys returned %v", err)
+	}
+	overlap := parseTestLexicon(t, `{"$lexicon": 1, "v": {"oneOf": ["string", "integer", "string|null", "string"]}}`).Compile()
+	doc := testTree(map[string]interface{}{"v": "a"})
+	if err, ok := overlap.ValidateBytes(doc.ToByteArray()).(ValidationError); !ok || err.Detail != "value matches branches 0, 2, 3" {
+		t.Fatalf("value matching several branches returned %v", err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		if err := validator.ValidateBytes(valid); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs > 0 {
+		t.Fatalf("ValidateBytes of a valid document allocated %v times", allocs)
+	}
+}
+
+func TestValidateBytesLimits(t *testing.T) {
+	nested := parseTestLexicon(t, `{
+		"$lexicon": 1,
+		"defs": {
+			"main": {"n": {"$ref": "#/defs/n"}},
+			"n": {"type": "array", "items": {"$ref": "#/defs/n"}}
+		}
+	}`).Compile()
+
+	// 1 MB of arrays nested inside each other, each with a four byte length
+	depth := 200000
+	deep := []byte{0x00, 'n'}
+	for i := 0; i < depth; i++ {
+		deep = append(deep, 0x35)
+		deep = binary.LittleEndian.AppendUint32(deep, uint32((depth-1-i)*5))
+	}
+	checkLimit := func(err error, limit string, path string) {
+		t.Helper()
+		var decodeErr *DecodeError
+		var limitErr *LimitError
+		if !errors.As(err, &decodeErr) || !errors.As(err, &limitErr) {
+			t.Fatalf("expected *DecodeError caused by *LimitError, got %v", err)
+		}
+		if limitErr.Limit != limit || !strings.HasPrefix(decodeErr.Path, path) {
+			t.Fatalf("expected %s exceeded at %s, got %v", limit, path, err)
+		}
+	}
+	checkLimit(nested.ValidateBytes(deep), "MaxDepth", "n[0][0]")
+	checkLimit(nested.ValidateBytesWithOptions(deep, DecodeOptions{MaxDepth: 10}), "MaxDepth", "n[0][0][0][0][0][0][0][0][0]")
+	checkLimit(nested.ValidateBytesWithOptions(deep, DecodeOptions{MaxElements: 1000}), "MaxElements", "n[0][0]")
+	checkLimit(nested.ValidateBytesWithOptions(deep, DecodeOptions{MaxDocumentSize: 1 << 16}), "MaxDocumentSize", "")
+
+	shallow := []byte{0x00, 'n'}
+	for i := 0; i < 10; i++ {
+		shallow = append(shallow, 0x35)
+		shallow = binary.LittleEndian.AppendUint32(shallow, uint32((9-i)*5))
+	}
+	if err := nested.ValidateBytesWithOptions(shallow, DecodeOptions{MaxDepth: 10, MaxElements: 10}); err != nil {
+		t.Fatalf("document at the limits rejected: %v", err)
+	}
+
+	// Values read by several union branches are counted once.
+	choice := parseTestLexicon(t, `{"$lexicon": 1, "v": {"oneOf": [{"a": "integer", "b": "integer"}, {"a": "string", "b": "string"}]}}`).Compile()
+	tree := testTree(map[string]interface{}{"v": testTree(map[string]interface{}{"a": "x", "b": "y"})})
+	doc := tree.ToByteArray()
+	if err := choice.ValidateBytesWithOptions(doc, DecodeOptions{MaxElements: 3}); err != nil {
+		t.Fatalf("union values counted more than once: %v", err)
+	}
+	checkLimit(choice.ValidateBytesWithOptions(doc, DecodeOptions{MaxElements: 2}), "MaxElements", "v.b")
+	checkLimit(choice.ValidateBytesWithOptions(doc, DecodeOptions{MaxKeys: 1}), "MaxKeys", "v")
+}
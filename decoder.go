@@ -0,0 +1,107 @@
+package abit
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Decoder reads an ABIT document from an input stream.
+//
+// A document has no length prefix of its own, so Decode reads until the
+// stream ends. Keys and values are decoded as they are read, with nested
+// trees and arrays decoded in place, so apart from the decoded document only
+// the key or value being read is held in memory. Blobs and strings grow as
+// their bytes arrive, a length prefix larger than the rest of the stream
+// does not allocate memory up front.
+//
+//	// Decode a document from a file or a socket
+//	dec := abit.NewDecoder(r)
+//	tree, err := dec.Decode()
+type Decoder struct {
+	src   streamSource
+	state decodeState
+	done  bool
+}
+
+// NewDecoder returns a Decoder that reads from r.
+//
+// The Decoder buffers its input, so it may read from r beyond the bytes it
+// needs.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		src: streamSource{r: bufio.NewReader(r)},
+	}
+}
+
+// Decode reads the document from the stream and returns it as an ABITObject.
+//
+// An empty stream decodes to an empty tree. Errors in the document are
+// returned as a *DecodeError, input that ends in the middle of a key or value
+// is reported as ErrTruncated. Once a document has been decoded, further
+// calls return io.EOF.
+//
+// # Example
+//
+//	tree, err := abit.NewDecoder(conn).Decode()
+//	if err != nil {
+//		// Handle invalid or truncated document here
+//	}
+func (d *Decoder) Decode() (*ABITObject, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	d.done = true
+
+	tree, err := d.state.decodeTree(&d.src, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// streamSource is a document read from a stream.
+type streamSource struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (src *streamSource) offset() int64 {
+	return src.pos
+}
+
+func (src *streamSource) atEnd() (bool, error) {
+	_, err := src.r.Peek(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+func (src *streamSource) readByte() (byte, error) {
+	b, err := src.r.ReadByte()
+	if err == io.EOF {
+		return 0, newDecodeError(src.pos, ErrTruncated)
+	}
+	if err != nil {
+		return 0, err
+	}
+	src.pos++
+	return b, nil
+}
+
+// read reads exactly n bytes. The buffer grows as data arrives, so a bogus
+// length prefix does not allocate memory up front.
+func (src *streamSource) read(n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	written, err := io.CopyN(&buf, src.r, n)
+	src.pos += written
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, newDecodeError(src.pos, ErrTruncated)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package abit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func decoderTestTree() *ABITObject {
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("null obj", Null{})
+	tree.Put("boolean obj", true)
+	tree.Put("integer obj", int64(-69696969420))
+	tree.Put("blob obj", randBytes(1024))
+	tree.Put("string obj", "Hello 💀")
+
+	arr := NewABITArray()
+	arr.Add("1")
+	arr.Add(int64(2))
+	nestedArr := NewABITArray()
+	nestedArr.Add(false)
+	arr.Add(*nestedArr)
+	tree.Put("array obj", *arr)
+
+	nestedTree, _ := NewABITObject(&[]byte{})
+	nestedTree.Put("thing", "AMOGUS")
+	nestedTree.Put("things", *arr)
+	tree.Put("nesty", *nestedTree)
+	return tree
+}
+
+func TestDecoder(t *testing.T) {
+	doc := decoderTestTree().ToByteArray()
+
+	readers := map[string]io.Reader{
+		"bytes":    bytes.NewReader(doc),
+		"one byte": iotest.OneByteReader(bytes.NewReader(doc)),
+		"half":     iotest.HalfReader(bytes.NewReader(doc)),
+	}
+	for name, r := range readers {
+		dec := NewDecoder(r)
+		tree, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		if !bytes.Equal(doc, tree.ToByteArray()) {
+			t.Fatalf("%s: abit not equal", name)
+		}
+		if _, err := dec.Decode(); err != io.EOF {
+			t.Fatalf("%s: expected io.EOF after document, got %v", name, err)
+		}
+	}
+
+	tree, err := NewDecoder(bytes.NewReader([]byte{})).Decode()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(tree.Keys()) != 0 {
+		t.Fatal("empty stream should decode to an empty tree")
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	doc := decoderTestTree().ToByteArray()
+
+	for i := 1; i < len(doc); i++ {
+		_, err := NewDecoder(bytes.NewReader(doc[:i])).Decode()
+		if err == nil {
+			// Cutting exactly between two root entries leaves a valid document.
+			continue
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("truncated at %d: expected io.ErrUnexpectedEOF, got %s", i, err.Error())
+		}
+	}
+}
+
+func TestDecoderInvalid(t *testing.T) {
+	for i := 0; i < 20000; i++ {
+		obj := randBytes(512)
+		_, err1 := NewABITObject(&obj)
+		_, err2 := NewDecoder(bytes.NewReader(obj)).Decode()
+		if (err1 == nil) != (err2 == nil) {
+			t.Fatalf("decoder disagrees with NewABITObject: %v <-> %v", err1, err2)
+		}
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// zeroReader is an endless stream of zero bytes.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestDecoderNested(t *testing.T) {
+	// A tree claiming 1 GiB of contents, which starts with an invalid type
+	header := []byte{0x00, 'a', 0x36, 0x00, 0x00, 0x00, 0x40, 0x00, 'b', 0x0f}
+	r := &countingReader{r: io.MultiReader(bytes.NewReader(header), zeroReader{})}
+	_, err := NewDecoder(r).Decode()
+	var decodeErr *DecodeError
+	if !errors.Is(err, ErrInvalidType) || !errors.As(err, &decodeErr) || decodeErr.Offset != 9 || decodeErr.Path != "a.b" {
+		t.Fatalf("expected ErrInvalidType at a.b, got %v", err)
+	}
+	if r.n > 1<<16 {
+		t.Fatalf("read %d bytes before the invalid type, nested trees must be decoded as they are read", r.n)
+	}
+}
@@ -0,0 +1,173 @@
+package abit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Encoder writes ABIT documents to an output stream.
+//
+// The size of every nested tree and array is worked out before anything is
+// written, so each byte of the document is written exactly once and no
+// intermediate copies of nested values are made.
+//
+//	// Encode a document to a file or a socket
+//	err := abit.NewEncoder(w).Encode(tree)
+type Encoder struct {
+	w      *bufio.Writer
+	trees  map[*ABITObject]int64
+	arrays map[*ABITArray]int64
+}
+
+// NewEncoder returns an Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Encode writes the binary ABIT document of tree to the stream.
+//
+// The output is identical to tree.ToByteArray().
+//
+// # Requirements
+//   - tree is an ABIT tree
+func (e *Encoder) Encode(tree *ABITObject) error {
+	if tree.dataType != 0b0110 {
+		return fmt.Errorf("ABITObject is not of type tree")
+	}
+	e.trees = map[*ABITObject]int64{}
+	e.arrays = map[*ABITArray]int64{}
+	defer func() {
+		e.trees = nil
+		e.arrays = nil
+	}()
+
+	if err := e.writeTreeBody(tree); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// treeSize returns the number of bytes used by the body of a tree, without
+// its type byte and length prefix.
+func (e *Encoder) treeSize(tree *ABITObject) int64 {
+	if size, ok := e.trees[tree]; ok {
+		return size
+	}
+	var size int64 = 0
+	for key, obj := range tree.tree {
+		size += 1 + int64(len(key)) + e.valueSize(obj)
+	}
+	e.trees[tree] = size
+	return size
+}
+
+// arraySize returns the number of bytes used by the body of an array,
+// without its type byte and length prefix.
+func (e *Encoder) arraySize(arr *ABITArray) int64 {
+	if size, ok := e.arrays[arr]; ok {
+		return size
+	}
+	var size int64 = 0
+	for _, obj := range arr.array {
+		size += e.valueSize(obj)
+	}
+	e.arrays[arr] = size
+	return size
+}
+
+// valueSize returns the number of bytes used by an encoded value.
+func (e *Encoder) valueSize(obj *ABITObject) int64 {
+	var length int64
+	switch obj.dataType {
+	case 0b0000, 0b0001:
+		return 1
+	case 0b0010:
+		return 1 + int64(integerSize(obj.integer))
+	case 0b0011:
+		length = int64(len(*obj.blob))
+	case 0b0100:
+		length = int64(len(*obj.text))
+	case 0b0101:
+		length = e.arraySize(obj.array)
+	case 0b0110:
+		length = e.treeSize(obj)
+	}
+	return 1 + int64(integerSize(length)) + length
+}
+
+func (e *Encoder) writeTreeBody(tree *ABITObject) error {
+	for _, key := range sortedKeys(tree.tree) {
+		if len(key) > 256 {
+			return fmt.Errorf("key too long")
+		} else if len(key) < 1 {
+			return fmt.Errorf("key too short")
+		}
+		if err := e.w.WriteByte(uint8(len(key) - 1)); err != nil {
+			return err
+		}
+		if _, err := e.w.WriteString(key); err != nil {
+			return err
+		}
+		if err := e.writeValue(tree.tree[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Encoder) writeValue(obj *ABITObject) error {
+	switch obj.dataType {
+	case 0b0000:
+		return e.w.WriteByte(0x00)
+	case 0b0001:
+		if obj.boolean {
+			return e.w.WriteByte(0x11)
+		}
+		return e.w.WriteByte(0x01)
+	case 0b0010:
+		return e.writeInteger(obj.integer, 0b0010)
+	case 0b0011:
+		if err := e.writeInteger(int64(len(*obj.blob)), 0b0011); err != nil {
+			return err
+		}
+		_, err := e.w.Write(*obj.blob)
+		return err
+	case 0b0100:
+		if err := e.writeInteger(int64(len(*obj.text)), 0b0100); err != nil {
+			return err
+		}
+		_, err := e.w.WriteString(*obj.text)
+		return err
+	case 0b0101:
+		if err := e.writeInteger(e.arraySize(obj.array), 0b0101); err != nil {
+			return err
+		}
+		for _, o := range obj.array.array {
+			if err := e.writeValue(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 0b0110:
+		if err := e.writeInteger(e.treeSize(obj), 0b0110); err != nil {
+			return err
+		}
+		return e.writeTreeBody(obj)
+	default:
+		return fmt.Errorf("object is of invalid type")
+	}
+}
+
+// writeInteger writes a type byte followed by value, like encodeInteger.
+func (e *Encoder) writeInteger(value int64, type_n uint8) error {
+	byteCount := integerSize(value)
+	var buf [9]byte
+	buf[0] = ((byteCount - 1) << 4) | (type_n & 0x0f)
+	binary.LittleEndian.PutUint64(buf[1:], uint64(value))
+	_, err := e.w.Write(buf[:1+byteCount])
+	return err
+}
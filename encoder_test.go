@@ -0,0 +1,39 @@
+package abit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncoder(t *testing.T) {
+	tree := decoderTestTree()
+	tree.Put(strings.Repeat(" ", 256), "long key")
+	tree.Put("big blob obj", randBytes(70000))
+
+	deep, _ := NewABITObject(&[]byte{})
+	for i := 0; i < 50; i++ {
+		next, _ := NewABITObject(&[]byte{})
+		next.Put("deeper", *deep)
+		next.Put("blob", randBytes(300))
+		deep = next
+	}
+	tree.Put("deep", *deep)
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(tree); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(buf.Bytes(), tree.ToByteArray()) {
+		t.Fatal("encoder output differs from ToByteArray")
+	}
+
+	empty, _ := NewABITObject(&[]byte{})
+	buf.Reset()
+	if err := NewEncoder(&buf).Encode(empty); err != nil {
+		t.Fatal(err.Error())
+	}
+	if buf.Len() != 0 {
+		t.Fatal("empty tree should encode to an empty document")
+	}
+}
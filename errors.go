@@ -0,0 +1,116 @@
+package abit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrTruncated means the document ended in the middle of an element. It
+	// wraps io.ErrUnexpectedEOF.
+	ErrTruncated = fmt.Errorf("document truncated: %w", io.ErrUnexpectedEOF)
+	// ErrKeyOrder means the keys of a tree are not in canonical order, or a
+	// key appears twice.
+	ErrKeyOrder = errors.New("invalid key order")
+	// ErrInvalidType means a type nibble does not name an ABIT type.
+	ErrInvalidType = errors.New("invalid type")
+	// ErrInvalidValue means the byte of a null or boolean is not valid.
+	ErrInvalidValue = errors.New("invalid value")
+	// ErrIntegerTooLarge means an integer or length prefix uses more bytes
+	// than allowed.
+	ErrIntegerTooLarge = errors.New("integer too large")
+	// ErrNegativeLength means a length prefix is negative.
+	ErrNegativeLength = errors.New("negative length")
+	// ErrOverrun means an element does not end where its tree or array ends.
+	ErrOverrun = errors.New("element exceeds its container")
+)
+
+// DecodeError describes where and why a document could not be decoded.
+//
+// Err is one of the sentinel errors of this package, so the cause can be
+// checked with errors.Is.
+//
+// # Example
+//
+//	_, err := abit.NewABITObject(&doc)
+//	var decodeErr *abit.DecodeError
+//	if errors.As(err, &decodeErr) {
+//		fmt.Println(decodeErr.Offset, decodeErr.Path)
+//	}
+//	if errors.Is(err, abit.ErrTruncated) {
+//		// Handle incomplete document here
+//	}
+type DecodeError struct {
+	// Offset is the position in the document of the byte that failed.
+	Offset int64
+	// Path leads to the failing element, such as profile.tags[3]. It is
+	// empty for errors in the document root.
+	Path string
+	// Expected is the type the element should have had, or KindInvalid if
+	// the element was not of a known type.
+	Expected Kind
+	// Found is the type nibble of the element, or KindInvalid if the type
+	// could not be read.
+	Found Kind
+	// Err is the cause of the error.
+	Err error
+}
+
+func newDecodeError(offset int64, err error) *DecodeError {
+	return &DecodeError{
+		Offset:   offset,
+		Expected: KindInvalid,
+		Found:    KindInvalid,
+		Err:      err,
+	}
+}
+
+func (e *DecodeError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	fmt.Fprintf(&b, " at offset %d", e.Offset)
+	if e.Path != "" {
+		fmt.Fprintf(&b, " in %s", e.Path)
+	}
+	switch {
+	case e.Expected != KindInvalid && e.Found != KindInvalid:
+		fmt.Fprintf(&b, " (expected %s, found %s)", e.Expected, e.Found)
+	case e.Found != KindInvalid:
+		fmt.Fprintf(&b, " (found %s)", e.Found)
+	}
+	return b.String()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// indexSegment returns the path segment of an index in an array.
+func indexSegment(index int) string {
+	return fmt.Sprintf("[%d]", index)
+}
+
+// appendPath appends the path of a child to the segment of its parent.
+func appendPath(segment string, child string) string {
+	if child == "" || strings.HasPrefix(child, "[") {
+		return segment + child
+	}
+	if segment == "" {
+		return child
+	}
+	return segment + "." + child
+}
+
+// locateDecodeError moves a DecodeError returned for a nested element to the
+// location of the element in its parent. base is added to the offset and
+// segment is prepended to the path.
+func locateDecodeError(err error, base int64, segment string) error {
+	var decodeErr *DecodeError
+	if errors.As(err, &decodeErr) {
+		decodeErr.Offset += base
+		decodeErr.Path = appendPath(segment, decodeErr.Path)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package abit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// corruptedDocument returns a document where the type nibble of
+// profile.tags[3] is invalid, and the offset of that byte.
+func corruptedDocument(t *testing.T) ([]byte, int64) {
+	t.Helper()
+	tags := NewABITArray()
+	tags.Add("a")
+	tags.Add("b")
+	tags.Add(int64(3))
+	tags.Add("d")
+	profile, _ := NewABITObject(&[]byte{})
+	profile.Put("name", "päror")
+	profile.Put("tags", *tags)
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("id", int64(1))
+	tree.Put("profile", *profile)
+	doc := tree.ToByteArray()
+
+	r := NewTokenReader(doc)
+	var offset int64 = -1
+	for {
+		tok, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if tok.Kind == TokenString && string(tok.Bytes) == "d" {
+			offset = tok.Offset
+		}
+	}
+	doc[offset] = 0x07
+	return doc, offset
+}
+
+func TestDecodeError(t *testing.T) {
+	doc, offset := corruptedDocument(t)
+
+	_, err1 := NewABITObject(&doc)
+	_, err2 := NewDecoder(bytes.NewReader(doc)).Decode()
+	r := NewTokenReader(doc)
+	var err3 error
+	for err3 == nil {
+		_, err3 = r.Next()
+	}
+
+	for _, err := range []error{err1, err2, err3} {
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf("expected *DecodeError, got %v", err)
+		}
+		if !errors.Is(err, ErrInvalidType) {
+			t.Fatalf("expected ErrInvalidType, got %v", err)
+		}
+		if decodeErr.Offset != offset {
+			t.Fatalf("expected offset %d, got %d", offset, decodeErr.Offset)
+		}
+		if decodeErr.Path != "profile.tags[3]" {
+			t.Fatalf("expected path profile.tags[3], got %s", decodeErr.Path)
+		}
+		if decodeErr.Found != 7 {
+			t.Fatalf("expected type nibble 7, got %d", decodeErr.Found)
+		}
+	}
+}
+
+func TestDecodeErrorCauses(t *testing.T) {
+	doc := decoderTestTree().ToByteArray()
+	truncated := doc[:len(doc)-1]
+	if _, err := NewABITObject(&truncated); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("expected ErrTruncated, got %v", err)
+	}
+
+	// "b" before "a"
+	unordered := []byte{0x00, 'b', 0x00, 0x00, 'a', 0x00}
+	_, err := NewABITObject(&unordered)
+	if !errors.Is(err, ErrKeyOrder) {
+		t.Fatalf("expected ErrKeyOrder, got %v", err)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Offset != 3 || decodeErr.Path != "a" {
+		t.Fatalf("incorrect location: %v", err)
+	}
+
+	badBool := []byte{0x00, 'a', 0x21}
+	_, err = NewABITObject(&badBool)
+	if !errors.Is(err, ErrInvalidValue) || !errors.As(err, &decodeErr) || decodeErr.Expected != KindBoolean {
+		t.Fatalf("expected ErrInvalidValue for a boolean, got %v", err)
+	}
+
+	negative := []byte{0x00, 'a', 0x03, 0xff}
+	if _, err := NewABITObject(&negative); !errors.Is(err, ErrNegativeLength) {
+		t.Fatalf("expected ErrNegativeLength, got %v", err)
+	}
+
+	// A nested tree of 3 bytes containing a key and value of 4 bytes
+	overrun := []byte{0x00, 'a', 0x06, 0x03, 0x00, 'b', 0x02, 0x05}
+	if _, err := NewABITObject(&overrun); !errors.Is(err, ErrOverrun) {
+		t.Fatalf("expected ErrOverrun, got %v", err)
+	}
+	// A nested array of 1 byte containing an integer of 2 bytes
+	overrun = []byte{0x00, 'a', 0x05, 0x01, 0x02, 0x05, 0x00}
+	_, err = NewABITObject(&overrun)
+	if !errors.Is(err, ErrOverrun) || !errors.As(err, &decodeErr) || decodeErr.Offset != 4 || decodeErr.Path != "a[0]" {
+		t.Fatalf("expected ErrOverrun at a[0], got %v", err)
+	}
+}
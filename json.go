@@ -0,0 +1,247 @@
+package abit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/multiformats/go-multibase"
+)
+
+// FromJSON creates an ABIT object from JSON in the format written by ToJson.
+//
+// The conventions of ToJson are followed:
+//   - the JSON document must be an object, which becomes the tree
+//   - keys ending with "_b" hold blobs as multibase strings, the suffix is removed from the key
+//   - numbers must be integers that fit in an int64
+//   - objects become trees and arrays become ABITArrays
+//
+// Strings inside arrays are always read as strings, as ToJson writes blobs
+// in arrays the same way as strings.
+//
+// # Example
+//
+//	tree, err := abit.FromJSON([]byte(`{"landet":["päror",46410],"riktnummer_b":"z13DUyZY2dc"}`))
+//	if err != nil {
+//		// Handle JSON that can not be represented in abit here
+//	}
+func FromJSON(data []byte) (*ABITObject, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("JSON document must be an object")
+	}
+	tree, err := jsonToTree(m, "")
+	if err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// decodeJSON decodes a single JSON value, keeping numbers as json.Number.
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON document")
+	}
+	return value, nil
+}
+
+// decodeMultibase decodes a multibase string. Unlike multibase.Decode it
+// accepts the bare prefix multibase.Encode writes for empty blobs.
+func decodeMultibase(s string) ([]byte, error) {
+	if len(s) == 1 && multibase.EncodingToStr[multibase.Encoding(s[0])] != "" {
+		return []byte{}, nil
+	}
+	_, blob, err := multibase.Decode(s)
+	return blob, err
+}
+
+// jsonInteger converts a JSON number to an integer.
+func jsonInteger(n json.Number, path string) (int64, error) {
+	i, err := strconv.ParseInt(n.String(), 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, fmt.Errorf("integer %s at %s does not fit in int64", n, pathOrRoot(path))
+		}
+		return 0, fmt.Errorf("number %s at %s is not an integer", n, pathOrRoot(path))
+	}
+	return i, nil
+}
+
+// jsonToValue converts a decoded JSON value to a value accepted by Put.
+func jsonToValue(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return Null{}, nil
+	case bool:
+		return v, nil
+	case json.Number:
+		return jsonInteger(v, path)
+	case string:
+		return v, nil
+	case []interface{}:
+		arr := NewABITArray()
+		for i := range v {
+			o, err := jsonToValue(v[i], appendPath(path, indexSegment(i)))
+			if err != nil {
+				return nil, err
+			}
+			arr.Add(o)
+		}
+		return *arr, nil
+	case map[string]interface{}:
+		return jsonToTree(v, path)
+	}
+	return nil, fmt.Errorf("unsupported JSON value at %s", pathOrRoot(path))
+}
+
+func jsonToTree(m map[string]interface{}, path string) (ABITObject, error) {
+	tree, _ := NewABITObject(&[]byte{})
+	for key, value := range m {
+		var o interface{}
+		var err error
+		if s, ok := value.(string); ok && strings.HasSuffix(key, "_b") {
+			blob, decodeErr := decodeMultibase(s)
+			if decodeErr != nil {
+				return *tree, fmt.Errorf("blob at %s is not multibase: %w", appendPath(path, key), decodeErr)
+			}
+			key = strings.TrimSuffix(key, "_b")
+			o = blob
+		} else {
+			o, err = jsonToValue(value, appendPath(path, key))
+			if err != nil {
+				return *tree, err
+			}
+		}
+		if err := checkKey(key, path); err != nil {
+			return *tree, err
+		}
+		if tree.Has(key) {
+			return *tree, fmt.Errorf("duplicate key %q at %s", key, pathOrRoot(path))
+		}
+		tree.Put(key, o)
+	}
+	return *tree, nil
+}
+
+// FromTypedJSON creates an ABIT object from JSON written with the TypedJSON
+// mapping of a JSONEncoder. Unlike FromJSON no information is lost, converting
+// a tree to typed JSON and back gives an identical document.
+//
+// Objects with a single key starting with "$" are wrappers:
+//   - {"$blob":"<multibase>"} is a blob
+//   - {"$int":"<decimal>"} is an integer
+//   - {"$str":"<multibase>"} is a string that is not valid UTF-8
+//   - {"$tree":{...}} is a tree, used for trees with a single key starting with "$"
+//
+// # Example
+//
+//	tree, err := abit.FromTypedJSON([]byte(`{"id":{"$int":"9007199254740993"},"key":{"$blob":"z13DUyZY2dc"}}`))
+//	if err != nil {
+//		// Handle invalid typed JSON here
+//	}
+func FromTypedJSON(data []byte) (*ABITObject, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	o, err := typedJSONToValue(value, "")
+	if err != nil {
+		return nil, err
+	}
+	tree, ok := o.(ABITObject)
+	if !ok {
+		return nil, fmt.Errorf("JSON document must be a tree")
+	}
+	return &tree, nil
+}
+
+// typedJSONToValue converts a decoded typed JSON value to a value accepted by
+// Put.
+func typedJSONToValue(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		arr := NewABITArray()
+		for i := range v {
+			o, err := typedJSONToValue(v[i], appendPath(path, indexSegment(i)))
+			if err != nil {
+				return nil, err
+			}
+			arr.Add(o)
+		}
+		return *arr, nil
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return typedJSONToTree(v, path)
+		}
+		for key, inner := range v {
+			if !strings.HasPrefix(key, "$") {
+				return typedJSONToTree(v, path)
+			}
+			switch key {
+			case "$int":
+				s, ok := inner.(string)
+				if !ok {
+					return nil, fmt.Errorf("$int at %s must be a string", pathOrRoot(path))
+				}
+				return jsonInteger(json.Number(s), path)
+			case "$blob":
+				s, ok := inner.(string)
+				if !ok || s == "" {
+					return nil, fmt.Errorf("$blob at %s must be a multibase string", pathOrRoot(path))
+				}
+				blob, err := decodeMultibase(s)
+				if err != nil {
+					return nil, fmt.Errorf("blob at %s is not multibase: %w", pathOrRoot(path), err)
+				}
+				return blob, nil
+			case "$str":
+				s, ok := inner.(string)
+				if !ok || s == "" {
+					return nil, fmt.Errorf("$str at %s must be a multibase string", pathOrRoot(path))
+				}
+				text, err := decodeMultibase(s)
+				if err != nil {
+					return nil, fmt.Errorf("string at %s is not multibase: %w", pathOrRoot(path), err)
+				}
+				return string(text), nil
+			case "$tree":
+				m, ok := inner.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("$tree at %s must be an object", pathOrRoot(path))
+				}
+				return typedJSONToTree(m, path)
+			}
+			return nil, fmt.Errorf("unknown wrapper %q at %s", key, pathOrRoot(path))
+		}
+	}
+	return jsonToValue(value, path)
+}
+
+func typedJSONToTree(m map[string]interface{}, path string) (ABITObject, error) {
+	tree, _ := NewABITObject(&[]byte{})
+	for key, value := range m {
+		if err := checkKey(key, path); err != nil {
+			return *tree, err
+		}
+		o, err := typedJSONToValue(value, appendPath(path, key))
+		if err != nil {
+			return *tree, err
+		}
+		tree.Put(key, o)
+	}
+	return *tree, nil
+}
@@ -0,0 +1,152 @@
+package abit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/multiformats/go-multibase"
+)
+
+func TestFromJSON(t *testing.T) {
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("null obj", Null{})
+	tree.Put("boolean obj", true)
+	tree.Put("integer max", int64(9223372036854775807))
+	tree.Put("integer min", int64(-9223372036854775808))
+	tree.Put("blob obj", randBytes(1024))
+	tree.Put("empty blob", []byte{})
+	tree.Put("string obj", "Hello 💀 \"quoted\"\n")
+	arr := NewABITArray()
+	arr.Add("1")
+	arr.Add(int64(2))
+	arr.Add(Null{})
+	tree.Put("array obj", *arr)
+	nestedTree, _ := NewABITObject(&[]byte{})
+	nestedTree.Put("thing", "AMOGUS")
+	nestedTree.Put("bytes", []byte{0, 4, 1, 0})
+	tree.Put("nesty", *nestedTree)
+
+	tree2, err := FromJSON([]byte(tree.ToJson()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !bytes.Equal(tree.ToByteArray(), tree2.ToByteArray()) {
+		t.Fatal("abit not equal")
+	}
+
+	edited, err := FromJSON([]byte(`{"landet":["päror",46410],"riktnummer_b":"z13DUyZY2dc","mer":{"ja":true}}`))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if *edited.GetArray("landet").GetString(0) != "päror" || !bytes.Equal(*edited.GetBlob("riktnummer"), []byte{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Fatal("incorrect value")
+	}
+
+	invalid := []string{
+		`{"float":1.5}`,
+		`{"exponent":1e3}`,
+		`{"big":9223372036854775808}`,
+		`{"arr":[1,2.5]}`,
+		`{"blob_b":"not multibase!"}`,
+		`{"blob_b":""}`,
+		`{"a":1,"a_b":"z13DUyZY2dc"}`,
+		`{"_b":"z13DUyZY2dc"}`,
+		`{"":1}`,
+		`[1,2]`,
+		`{"a":1} {"b":2}`,
+		`{"a":`,
+	}
+	for _, doc := range invalid {
+		if _, err := FromJSON([]byte(doc)); err == nil {
+			t.Fatalf("%s should not be accepted", doc)
+		}
+	}
+}
+
+func TestTypedJSON(t *testing.T) {
+	tree := decoderTestTree()
+	tree.Put("name_b", "not a blob")
+	tree.Put("safe", int64(9007199254740991))
+	tree.Put("unsafe", int64(9007199254740993))
+	tree.Put("min", int64(-9223372036854775808))
+	tree.Put("empty blob", []byte{})
+	arr := NewABITArray()
+	arr.Add([]byte{1, 2, 3})
+	arr.Add("z13DUyZY2dc")
+	arr.Add(int64(-9007199254740992))
+	arr.Add(*NewABITArray())
+	tree.Put("mixed", *arr)
+	dollar, _ := NewABITObject(&[]byte{})
+	dollar.Put("$int", "looks like a wrapper")
+	tree.Put("dollar", *dollar)
+	empty, _ := NewABITObject(&[]byte{})
+	tree.Put("empty", *empty)
+	tree.Put("invalid utf8", "\xc3(")
+	arr.Add("\xff")
+	tree.Put("mixed", *arr)
+
+	for _, indent := range []string{"", "  "} {
+		var out strings.Builder
+		enc := NewJSONEncoder(&out)
+		enc.SetMapping(TypedJSON)
+		enc.SetIndent("", indent)
+		if err := enc.Encode(tree); err != nil {
+			t.Fatal(err.Error())
+		}
+		if !strings.Contains(out.String(), `"$int"`) || !strings.Contains(out.String(), `"$tree"`) || !strings.Contains(out.String(), `"$str"`) {
+			t.Fatalf("missing wrappers: %s", out.String())
+		}
+		tree2, err := FromTypedJSON([]byte(out.String()))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if !bytes.Equal(tree.ToByteArray(), tree2.ToByteArray()) {
+			t.Fatalf("abit not equal after round trip of %s", out.String())
+		}
+	}
+
+	// Documents with only a wrapper key at the root
+	root, _ := NewABITObject(&[]byte{})
+	root.Put("$blob", int64(1))
+	var out strings.Builder
+	enc := NewJSONEncoder(&out)
+	enc.SetMapping(TypedJSON)
+	if err := enc.Encode(root); err != nil {
+		t.Fatal(err.Error())
+	}
+	root2, err := FromTypedJSON([]byte(out.String()))
+	if err != nil || !bytes.Equal(root.ToByteArray(), root2.ToByteArray()) {
+		t.Fatalf("abit not equal after round trip of %s", out.String())
+	}
+
+	enc.SetBlobEncoding(HexBlobs)
+	if err := enc.Encode(root); err == nil {
+		t.Fatal("typed JSON should require a multibase blob encoding")
+	}
+	invalidKey, _ := NewABITObject(&[]byte{})
+	invalidKey.Put("\xc3(", int64(1))
+	enc.SetBlobEncoding(MultibaseBlobs(multibase.Base58BTC))
+	if err := enc.Encode(invalidKey); err == nil {
+		t.Fatal("typed JSON should reject keys that are not UTF-8")
+	}
+
+	invalid := []string{
+		`{"a":{"$int":5}}`,
+		`{"a":{"$int":"1.5"}}`,
+		`{"a":{"$int":"9223372036854775808"}}`,
+		`{"a":{"$blob":"not multibase!"}}`,
+		`{"a":{"$blob":""}}`,
+		`{"a":{"$tree":[]}}`,
+		`{"a":{"$str":1}}`,
+		`{"a":{"$float":"1.5"}}`,
+		`{"$int":"5"}`,
+		`{"a":1.5}`,
+		`[]`,
+	}
+	for _, doc := range invalid {
+		if _, err := FromTypedJSON([]byte(doc)); err == nil {
+			t.Fatalf("%s should not be accepted", doc)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package abit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/multiformats/go-multibase"
+)
+
+// BlobEncoding is the text encoding a JSONEncoder uses for blobs.
+type BlobEncoding struct {
+	base   multibase.Encoding
+	prefix bool
+}
+
+var (
+	// Base64Blobs writes blobs as padded standard base64 without a multibase prefix.
+	Base64Blobs = BlobEncoding{base: multibase.Base64pad}
+	// HexBlobs writes blobs as lowercase hex without a multibase prefix.
+	HexBlobs = BlobEncoding{base: multibase.Base16}
+)
+
+// MultibaseBlobs returns a BlobEncoding writing blobs as multibase strings
+// in base. This is the only kind of blob encoding FromJSON can read back.
+func MultibaseBlobs(base multibase.Encoding) BlobEncoding {
+	return BlobEncoding{base: base, prefix: true}
+}
+
+func (b BlobEncoding) encode(blob []byte) (string, error) {
+	s, err := multibase.Encode(b.base, blob)
+	if err != nil {
+		return "", err
+	}
+	if !b.prefix {
+		_, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+	}
+	return s, nil
+}
+
+// KeyOrder is the order a JSONEncoder writes the keys of a tree in.
+type KeyOrder uint8
+
+const (
+	// CanonicalKeyOrder sorts keys by length and then bytewise, the order keys
+	// are stored in ABIT documents.
+	CanonicalKeyOrder KeyOrder = iota
+	// LexicographicKeyOrder sorts keys bytewise.
+	LexicographicKeyOrder
+)
+
+// JSONMapping is the way a JSONEncoder maps ABIT values to JSON.
+type JSONMapping uint8
+
+const (
+	// PlainJSON is the mapping used by ToJson and read by FromJSON. Blobs are
+	// strings stored under keys with a "_b" suffix, which makes blobs in
+	// arrays look like strings and large integers lose precision in most
+	// JSON readers.
+	PlainJSON JSONMapping = iota
+	// TypedJSON is a lossless mapping read by FromTypedJSON. Values that JSON
+	// can not represent exactly are wrapped in an object with a single key:
+	//   - blobs are {"$blob":"<multibase>"}
+	//   - integers outside ±(2^53-1) are {"$int":"<decimal>"}
+	//   - strings that are not valid UTF-8 are {"$str":"<multibase>"}
+	//   - trees with a single key starting with "$" are {"$tree":{...}}
+	//
+	// Keys that are not valid UTF-8 can not be written and are an error.
+	TypedJSON
+)
+
+// maxSafeInteger is the largest integer every JSON reader can represent
+// exactly.
+const maxSafeInteger = 1<<53 - 1
+
+// JSONEncoder writes ABIT trees as JSON to an output stream.
+//
+// Blobs in trees are written under their key with "_b" appended, the same
+// way as ToJson. By default blobs are Base58BTC multibase strings, keys are
+// in canonical order and no indentation is used.
+//
+//	enc := abit.NewJSONEncoder(os.Stdout)
+//	enc.SetIndent("", "  ")
+//	enc.SetBlobEncoding(abit.Base64Blobs)
+//	err := enc.Encode(tree)
+type JSONEncoder struct {
+	w       *bufio.Writer
+	prefix  string
+	indent  string
+	blobs   BlobEncoding
+	order   KeyOrder
+	mapping JSONMapping
+}
+
+// NewJSONEncoder returns a JSONEncoder that writes to w.
+func NewJSONEncoder(w io.Writer) *JSONEncoder {
+	return &JSONEncoder{
+		w:     bufio.NewWriter(w),
+		blobs: MultibaseBlobs(multibase.Base58BTC),
+	}
+}
+
+// SetIndent makes the encoder write every value on its own line, starting
+// with prefix and one copy of indent per level of nesting, like
+// json.MarshalIndent. Empty prefix and indent turn indentation off.
+func (e *JSONEncoder) SetIndent(prefix, indent string) {
+	e.prefix = prefix
+	e.indent = indent
+}
+
+// SetBlobEncoding sets the encoding used for blobs.
+func (e *JSONEncoder) SetBlobEncoding(encoding BlobEncoding) {
+	e.blobs = encoding
+}
+
+// SetKeyOrder sets the order keys of trees are written in.
+func (e *JSONEncoder) SetKeyOrder(order KeyOrder) {
+	e.order = order
+}
+
+// SetMapping sets the way values are mapped to JSON. TypedJSON requires a
+// multibase blob encoding.
+func (e *JSONEncoder) SetMapping(mapping JSONMapping) {
+	e.mapping = mapping
+}
+
+// Encode writes the JSON representation of tree to the stream.
+//
+// With the default settings the output is identical to tree.ToJson().
+//
+// # Requirements
+//   - tree is an ABIT tree
+func (e *JSONEncoder) Encode(tree *ABITObject) error {
+	if tree.dataType != 0b0110 {
+		return fmt.Errorf("ABITObject is not of type tree")
+	}
+	if e.mapping == TypedJSON && !e.blobs.prefix {
+		return fmt.Errorf("typed JSON requires a multibase blob encoding")
+	}
+	if err := e.writeValue(tree, 0); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+func (e *JSONEncoder) keys(tree *ABITObject) []string {
+	keys := sortedKeys(tree.tree)
+	if e.order == LexicographicKeyOrder {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// newline starts a new line for a value at depth, when indenting.
+func (e *JSONEncoder) newline(depth int) {
+	if e.prefix == "" && e.indent == "" {
+		return
+	}
+	e.w.WriteByte('\n')
+	e.w.WriteString(e.prefix)
+	for i := 0; i < depth; i++ {
+		e.w.WriteString(e.indent)
+	}
+}
+
+func (e *JSONEncoder) writeString(s string) error {
+	safe, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	e.w.Write(safe)
+	return nil
+}
+
+func (e *JSONEncoder) writeTree(tree *ABITObject, depth int) error {
+	e.w.WriteByte('{')
+	keys := e.keys(tree)
+	for i, key := range keys {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		e.newline(depth + 1)
+		obj := tree.tree[key]
+		if e.mapping == TypedJSON && !utf8.ValidString(key) {
+			return fmt.Errorf("key %q is not valid UTF-8 and can not be written as typed JSON", key)
+		}
+		if obj.dataType == 0b0011 && e.mapping == PlainJSON {
+			key += "_b"
+		}
+		if err := e.writeString(key); err != nil {
+			return err
+		}
+		e.colon()
+		if err := e.writeValue(obj, depth+1); err != nil {
+			return err
+		}
+	}
+	if len(keys) > 0 {
+		e.newline(depth)
+	}
+	return e.w.WriteByte('}')
+}
+
+func (e *JSONEncoder) colon() {
+	e.w.WriteByte(':')
+	if e.indent != "" || e.prefix != "" {
+		e.w.WriteByte(' ')
+	}
+}
+
+// writeWrapper writes a TypedJSON wrapper object with the single key name.
+func (e *JSONEncoder) writeWrapper(name string, depth int, value func() error) error {
+	e.w.WriteByte('{')
+	e.newline(depth + 1)
+	e.writeString(name)
+	e.colon()
+	if err := value(); err != nil {
+		return err
+	}
+	e.newline(depth)
+	return e.w.WriteByte('}')
+}
+
+// needsWrapper reports whether a tree has to be wrapped in TypedJSON to not
+// be read as a wrapper.
+func needsWrapper(tree *ABITObject) bool {
+	if len(tree.tree) != 1 {
+		return false
+	}
+	for key := range tree.tree {
+		return strings.HasPrefix(key, "$")
+	}
+	return false
+}
+
+func (e *JSONEncoder) writeArray(arr *ABITArray, depth int) error {
+	e.w.WriteByte('[')
+	for i, obj := range arr.array {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		e.newline(depth + 1)
+		if err := e.writeValue(obj, depth+1); err != nil {
+			return err
+		}
+	}
+	if len(arr.array) > 0 {
+		e.newline(depth)
+	}
+	return e.w.WriteByte(']')
+}
+
+func (e *JSONEncoder) writeValue(obj *ABITObject, depth int) error {
+	switch obj.dataType {
+	case 0b0000:
+		e.w.WriteString("null")
+	case 0b0001:
+		e.w.WriteString(strconv.FormatBool(obj.boolean))
+	case 0b0010:
+		if e.mapping == TypedJSON && (obj.integer > maxSafeInteger || obj.integer < -maxSafeInteger) {
+			return e.writeWrapper("$int", depth, func() error {
+				return e.writeString(strconv.FormatInt(obj.integer, 10))
+			})
+		}
+		e.w.WriteString(strconv.FormatInt(obj.integer, 10))
+	case 0b0011:
+		blobString, err := e.blobs.encode(*obj.blob)
+		if err != nil {
+			return err
+		}
+		if e.mapping == TypedJSON {
+			return e.writeWrapper("$blob", depth, func() error {
+				return e.writeString(blobString)
+			})
+		}
+		return e.writeString(blobString)
+	case 0b0100:
+		if e.mapping == TypedJSON && !utf8.ValidString(*obj.text) {
+			// JSON strings can only hold valid UTF-8.
+			text, err := e.blobs.encode([]byte(*obj.text))
+			if err != nil {
+				return err
+			}
+			return e.writeWrapper("$str", depth, func() error {
+				return e.writeString(text)
+			})
+		}
+		return e.writeString(*obj.text)
+	case 0b0101:
+		return e.writeArray(obj.array, depth)
+	case 0b0110:
+		if e.mapping == TypedJSON && needsWrapper(obj) {
+			return e.writeWrapper("$tree", depth, func() error {
+				return e.writeTree(obj, depth+1)
+			})
+		}
+		return e.writeTree(obj, depth)
+	default:
+		return fmt.Errorf("invalid ABIT type %d", obj.dataType)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package abit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/multiformats/go-multibase"
+)
+
+func TestJSONEncoder(t *testing.T) {
+	tree := decoderTestTree()
+	emptyTree, _ := NewABITObject(&[]byte{})
+	tree.Put("empty tree", *emptyTree)
+	tree.Put("empty array", *NewABITArray())
+
+	var compact strings.Builder
+	if err := NewJSONEncoder(&compact).Encode(tree); err != nil {
+		t.Fatal(err.Error())
+	}
+	if compact.String() != tree.ToJson() {
+		t.Fatal("default output should be identical to ToJson")
+	}
+	if !json.Valid([]byte(compact.String())) || !strings.Contains(compact.String(), `"empty tree":{}`) || !strings.Contains(compact.String(), `"empty array":[]`) {
+		t.Fatal("invalid JSON")
+	}
+
+	var indented strings.Builder
+	enc := NewJSONEncoder(&indented)
+	enc.SetIndent("> ", "\t")
+	if err := enc.Encode(tree); err != nil {
+		t.Fatal(err.Error())
+	}
+	var expected bytes.Buffer
+	json.Indent(&expected, []byte(compact.String()), "> ", "\t")
+	if indented.String() != expected.String() {
+		t.Fatalf("incorrectly indented JSON:\n%s", indented.String())
+	}
+
+	var sorted strings.Builder
+	enc = NewJSONEncoder(&sorted)
+	enc.SetKeyOrder(LexicographicKeyOrder)
+	enc.SetBlobEncoding(Base64Blobs)
+	if err := enc.Encode(tree); err != nil {
+		t.Fatal(err.Error())
+	}
+	if !strings.HasPrefix(sorted.String(), `{"array obj":`) {
+		t.Fatalf("keys not sorted: %s", sorted.String())
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(sorted.String()), &out); err != nil {
+		t.Fatal(err.Error())
+	}
+	blob, err := base64.StdEncoding.DecodeString(out["blob obj_b"].(string))
+	if err != nil || !bytes.Equal(blob, *tree.GetBlob("blob obj")) {
+		t.Fatal("incorrect base64 blob")
+	}
+
+	small, _ := NewABITObject(&[]byte{})
+	small.Put("b", []byte{0xde, 0xad})
+	for _, c := range []struct {
+		encoding BlobEncoding
+		expected string
+	}{
+		{HexBlobs, `{"b_b":"dead"}`},
+		{Base64Blobs, `{"b_b":"3q0="}`},
+		{MultibaseBlobs(multibase.Base32), `{"b_b":"b32wq"}`},
+	} {
+		var out strings.Builder
+		enc := NewJSONEncoder(&out)
+		enc.SetBlobEncoding(c.encoding)
+		if err := enc.Encode(small); err != nil {
+			t.Fatal(err.Error())
+		}
+		if out.String() != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, out.String())
+		}
+	}
+
+	empty, _ := NewABITObject(&[]byte{})
+	if empty.ToJson() != "{}" {
+		t.Fatalf("expected {}, got %s", empty.ToJson())
+	}
+}
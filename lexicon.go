@@ -0,0 +1,1565 @@
+package abit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ABITLexicon stores a schema to see if a given ABITObject matches the schema.
+type ABITLexicon struct {
+	// id names the lexicon in references from other lexicons.
+	id   string
+	root *lexNode
+	// defs holds the named definitions, root is defs["main"].
+	defs map[string]*lexNode
+	// registry is the Registry the lexicon was added to.
+	registry *Registry
+}
+
+// lexNode is a parsed lexicon schema for a single value.
+type lexNode struct {
+	// kinds is the set of kinds the value may have, as 1 << Kind.
+	kinds uint8
+	// fields holds the keys of trees.
+	fields map[string]*lexField
+	// tuple holds the values of arrays, matched position by position.
+	tuple []*lexNode
+	// items is the schema of every value in arrays of any length, used in
+	// place of tuple when set.
+	items    *lexNode
+	minItems *int64
+	maxItems *int64
+	// minimum and maximum bound integers.
+	minimum *int64
+	maximum *int64
+	// minLength and maxLength bound the UTF-8 length of strings in bytes.
+	minLength *int64
+	maxLength *int64
+	pattern   *regexp.Regexp
+	format    string
+	// minSize and maxSize bound the length of blobs in bytes.
+	minSize *int64
+	maxSize *int64
+	// enum holds the allowed booleans, integers and strings, as bool, int64
+	// and string. Values of these kinds must be in enum when it is set.
+	// isConst is set if it was written as const.
+	enum    []interface{}
+	isConst bool
+	// additional is the schema of the keys of trees that are not in fields,
+	// trees can not have other keys if it is nil. The key constraints only
+	// apply to these keys.
+	additional   *lexNode
+	keyPattern   *regexp.Regexp
+	minKeyLength *int64
+	maxKeyLength *int64
+	// any is set for nodes matching every value, including the contents of
+	// arrays and trees.
+	any bool
+	// extends holds the parents of trees composed with extends or allOf and
+	// overlay the keys of the tree itself. fields holds the keys of both
+	// once the parents are composed, and is nil until then. path is where
+	// the tree is in the lexicon.
+	extends []*lexNode
+	overlay map[string]*lexField
+	path    string
+	// ref is set for references to definitions and union for unions, such
+	// nodes have no other fields.
+	ref   *lexRef
+	union *lexUnion
+}
+
+// lexUnion is a schema matched by any of its branches.
+type lexUnion struct {
+	// exclusive is set for oneOf, where exactly one branch must match.
+	exclusive bool
+	branches  []*lexNode
+	// discriminator is the key of trees naming their branch, with the name
+	// of each branch in names. It is empty for unions without names.
+	discriminator string
+	names         []string
+	// fallback is the schema of trees whose discriminator names no branch,
+	// such trees are rejected if it is nil.
+	fallback *lexNode
+}
+
+// lexRef is a reference to a definition, such as "#/defs/comment".
+type lexRef struct {
+	// id is the lexicon of the definition, or empty for the lexicon the
+	// reference is in.
+	id   string
+	name string
+	// target is the definition, nil until the reference is resolved.
+	target *lexNode
+	// scope is the id of the lexicon the reference is in.
+	scope string
+}
+
+func (r *lexRef) String() string {
+	return r.id + "#/defs/" + r.name
+}
+
+// lexField is a key of a tree in a lexicon.
+type lexField struct {
+	node     *lexNode
+	optional bool
+}
+
+// lexAnyKinds is the set of every kind.
+const lexAnyKinds uint8 = 1<<(KindTree+1) - 1
+
+func kindBit(kind Kind) uint8 {
+	return 1 << kind
+}
+
+// accepts reports whether a value of kind is allowed by the node.
+func (n *lexNode) accepts(kind Kind) bool {
+	return kind <= KindTree && n.kinds&kindBit(kind) != 0
+}
+
+// InitLexicon creates an ABITLexicon used for seeing if any ABITObject follows the given schema or not.
+//
+//   - lexicon is a json with the specified scema.
+//   - Returns ABITLexicon
+//
+// # Allowed types:
+//   - "null"
+//   - "boolean"
+//   - "integer"
+//   - "blob"
+//   - "string"
+//   - "any", which matches every value
+//
+// Types can be combined with "|", "string|null" allows both a string and
+// null. A key ending with "?" is optional and may be left out of the
+// document, the "?" is not part of the key.
+//
+// A JSON array is a tuple, the document must have an array of the same
+// length with matching values. A JSON object is a tree with the given keys.
+//
+// Lexicons with "$lexicon":1 at the root can also use the schemas below.
+// Without it every JSON object is a tree, so lexicons written before the
+// schemas existed keep their meaning even if they have keys such as "type",
+// "$ref" or "defs". With it a JSON object where every key is one of
+// the keywords below and one of them is "type" describes a value in more
+// detail:
+//   - {"type":"array","items":<schema>,"minItems":1,"maxItems":100} is an
+//     array of any length where every value matches items
+//   - {"type":"tree","keys":{...}} is a tree, for trees with only keyword keys
+//   - {"type":"tree","keys":{...},"additionalKeys":true} also allows keys
+//     not in keys, with any value or with values matching a schema in place
+//     of true
+//   - {"type":"map","values":<schema>} is a tree where every key has a value
+//     matching values
+//   - "keyPattern", "minKeyLength" and "maxKeyLength" constrain the keys of
+//     maps and the additional keys of trees, lengths are in UTF-8 bytes from
+//     1 to 256
+//   - {"type":"integer","minimum":0,"maximum":10} bounds an integer
+//   - {"type":"string","minLength":1,"maxLength":64,"pattern":"^[a-z]+$"}
+//     bounds the length of a string in UTF-8 bytes and matches it against a
+//     regular expression
+//   - {"type":"string","format":"uri"} requires a string to be in a known
+//     format, "uri" or "datetime" (RFC 3339)
+//   - {"type":"blob","minSize":1,"maxSize":1024} bounds the size of a blob
+//   - {"type":"string","enum":["draft","published"]} only allows the listed
+//     booleans, integers or strings, {"type":"integer","const":1} only one
+//
+// The type of a description may also be combined with "|", such as
+// "array|null".
+//
+// {"oneOf":[<schema>,...]} matches values that match exactly one of the
+// schemas, {"anyOf":[...]} values that match at least one. With
+// "discriminator":"$type" the schemas are named instead, {"oneOf":{"image":
+// <schema>,...}}, and a tree is matched against the schema named by its
+// "$type" key. The discriminator key is not part of the schemas. Trees
+// naming no schema are rejected, or matched against the schema in
+// "fallback":<schema> if it is set.
+//
+// {"type":"tree","extends":[<schema>,...],"keys":{...}} is a tree with the
+// keys of its parent trees and its own keys, which add keys or narrow
+// the schemas of inherited keys. Parents may not define the same key
+// differently. {"allOf":[<schema>,...]} is a tree with only the keys of its
+// parents. additionalKeys is not inherited.
+//
+// Schemas can be named in definitions and used with {"$ref":"#/defs/<name>"},
+// also inside themselves for recursive types. A lexicon with definitions is
+// written as {"id":"<id>","defs":{"main":{...},"<name>":<schema>}}, where
+// main is the root tree and the id is optional. References to the
+// definitions of other lexicons, "<id>#/defs/<name>" or "<id>" for main, are
+// resolved when the lexicons are added to a Registry.
+//
+// # Example:
+//
+//	abit.InitLexicon(`{
+//		"key1":"boolean",
+//		"key2":{
+//			"nested":"key"
+//		},
+//		"key3":[
+//			"string"
+//			"string"
+//			"null"
+//		],
+//		"nickname?":"string|null"
+//	}`)
+//	abit.InitLexicon(`{
+//		"$lexicon":1,
+//		"name":{"type":"string","maxLength":64},
+//		"tags":{"type":"array","items":"string","maxItems":10}
+//	}`)
+//
+// InitLexicon panics if the lexicon is invalid, use ParseLexiconJSON to get
+// an error instead.
+func InitLexicon(lexicon string) ABITLexicon {
+	lex, err := ParseLexiconJSON([]byte(lexicon))
+	if err != nil {
+		panic(err.Error())
+	}
+	return *lex
+}
+
+// ParseLexiconJSON creates an ABITLexicon from a schema in the JSON format
+// described at InitLexicon.
+//
+// error is nil on success or describes why the lexicon is invalid.
+//
+// # Example
+//
+//	lex, err := abit.ParseLexiconJSON([]byte(`{"name":"string"}`))
+//	if err != nil {
+//		// Handle invalid lexicon here
+//	}
+func ParseLexiconJSON(data []byte) (*ABITLexicon, error) {
+	value, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return parseLexicon(value)
+}
+
+// lexVersionKey is the root key of lexicons using schema descriptions,
+// references, unions and definitions, with lexVersion as value. The value is
+// a number, which lexicons without it can not hold, so lexicons written
+// before keep their meaning.
+const (
+	lexVersionKey = "$lexicon"
+	lexVersion    = 1
+)
+
+// parseLexicon creates an ABITLexicon from a decoded JSON lexicon.
+func parseLexicon(value interface{}) (*ABITLexicon, error) {
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("lexicon must be a JSON object")
+	}
+	version, versioned := m[lexVersionKey].(json.Number)
+	if versioned {
+		if version.String() != strconv.Itoa(lexVersion) {
+			return nil, fmt.Errorf("%s %s is not supported, it must be %d", lexVersionKey, version, lexVersion)
+		}
+		keys := make(map[string]interface{}, len(m)-1)
+		for key, value := range m {
+			if key != lexVersionKey {
+				keys[key] = value
+			}
+		}
+		m = keys
+	}
+	lex, err := parseLexiconBody(m, versioned)
+	if err != nil && !versioned {
+		if path := findLexKeyword(m, ""); path != "" {
+			return nil, fmt.Errorf("%w, key %s is only a keyword in lexicons with \"%s\":%d at the root", err, path, lexVersionKey, lexVersion)
+		}
+	}
+	return lex, err
+}
+
+// parseLexiconBody parses a lexicon without lexVersionKey. Without versioned
+// every JSON object is a tree.
+func parseLexiconBody(m map[string]interface{}, versioned bool) (*ABITLexicon, error) {
+	if !versioned {
+		keys, err := upgradeLexKeys(m, "")
+		if err != nil {
+			return nil, err
+		}
+		m = keys
+	}
+	lex := &ABITLexicon{
+		defs: map[string]*lexNode{},
+	}
+	if !versioned || !isLexEnvelope(m) {
+		root, err := parseLexTree(m, "")
+		if err != nil {
+			return nil, err
+		}
+		lex.defs["main"] = root
+	} else if err := lex.parseEnvelope(m); err != nil {
+		return nil, err
+	}
+	lex.root = lex.defs["main"]
+	if err := lex.link(nil); err != nil {
+		return nil, err
+	}
+	if err := lex.compose(false); err != nil {
+		return nil, err
+	}
+	return lex, nil
+}
+
+// upgradeLexKeys converts the keys of a tree in a lexicon without
+// lexVersionKey, where every JSON object is a tree, to the syntax of lexicons
+// with it.
+func upgradeLexKeys(keys map[string]interface{}, path string) (map[string]interface{}, error) {
+	upgraded := make(map[string]interface{}, len(keys))
+	for key, value := range keys {
+		v, err := upgradeLexSchema(value, appendPath(path, strings.TrimSuffix(key, "?")))
+		if err != nil {
+			return nil, err
+		}
+		upgraded[key] = v
+	}
+	return upgraded, nil
+}
+
+func upgradeLexSchema(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		tuple := make([]interface{}, len(v))
+		for i := range v {
+			item, err := upgradeLexSchema(v[i], appendPath(path, indexSegment(i)))
+			if err != nil {
+				return nil, err
+			}
+			tuple[i] = item
+		}
+		return tuple, nil
+	case map[string]interface{}:
+		keys, err := upgradeLexKeys(v, path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "tree", "keys": keys}, nil
+	}
+	return nil, fmt.Errorf("schema at %s must be either a string, array or tree", pathOrRoot(path))
+}
+
+// lexSchemaKeys are the keys that make a JSON object a schema other than a
+// tree in lexicons with lexVersionKey, and are plain keys without it.
+var lexSchemaKeys = map[string]bool{"type": true, "$ref": true, "oneOf": true, "anyOf": true, "allOf": true, "defs": true}
+
+// findLexKeyword returns the path of the first key in the keys of a lexicon
+// without lexVersionKey that is a keyword with it, or "" if there is none.
+func findLexKeyword(keys map[string]interface{}, path string) string {
+	for _, key := range sortedSourceKeys(keys) {
+		keyPath := appendPath(path, strings.TrimSuffix(key, "?"))
+		if lexSchemaKeys[strings.TrimSuffix(key, "?")] {
+			return keyPath
+		}
+		if found := findLexSchemaKeyword(keys[key], keyPath); found != "" {
+			return found
+		}
+	}
+	return ""
+}
+
+func findLexSchemaKeyword(value interface{}, path string) string {
+	switch v := value.(type) {
+	case []interface{}:
+		for i := range v {
+			if found := findLexSchemaKeyword(v[i], appendPath(path, indexSegment(i))); found != "" {
+				return found
+			}
+		}
+	case map[string]interface{}:
+		return findLexKeyword(v, path)
+	}
+	return ""
+}
+
+// isLexEnvelope reports whether the root of a JSON lexicon holds named
+// definitions rather than the keys of the root tree.
+func isLexEnvelope(lexicon map[string]interface{}) bool {
+	if _, ok := lexicon["defs"]; !ok {
+		return false
+	}
+	for key := range lexicon {
+		if key != "id" && key != "defs" {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *ABITLexicon) parseEnvelope(lexicon map[string]interface{}) error {
+	if v, ok := lexicon["id"]; ok {
+		id, ok := v.(string)
+		if !ok || id == "" || strings.Contains(id, "#") {
+			return fmt.Errorf("id of lexicon must be a non-empty string without \"#\"")
+		}
+		l.id = id
+	}
+	defs, ok := lexicon["defs"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("defs of lexicon must be an object")
+	}
+	main, ok := defs["main"]
+	if !ok {
+		return fmt.Errorf("defs of lexicon is missing main")
+	}
+	if m, ok := main.(map[string]interface{}); !ok || (isLexDescription(m) && m["type"] != "tree" && m["type"] != "map") {
+		return fmt.Errorf("main at defs.main must be a tree or a reference")
+	}
+	for name, value := range defs {
+		node, err := parseLexNode(value, appendPath("defs", name))
+		if err != nil {
+			return err
+		}
+		l.defs[name] = node
+	}
+	return nil
+}
+
+// emptyLexTree is the root of the zero ABITLexicon, which like InitLexicon(`{}`)
+// only matches empty trees.
+var emptyLexTree = &lexNode{
+	kinds:  kindBit(KindTree),
+	fields: map[string]*lexField{},
+}
+
+// rootNode returns the schema of documents, emptyLexTree for the zero
+// ABITLexicon.
+func (l *ABITLexicon) rootNode() *lexNode {
+	if l.root == nil {
+		return emptyLexTree
+	}
+	return l.root
+}
+
+// ID returns the id of the lexicon, or an empty string if it has none.
+func (l *ABITLexicon) ID() string {
+	return l.id
+}
+
+// parseLexNode parses the schema of a single value.
+func parseLexNode(value interface{}, path string) (*lexNode, error) {
+	switch t := value.(type) {
+	case string:
+		return parseLexKinds(t, path, false)
+	case []interface{}: // Array
+		return parseLexTuple(t, path)
+	case map[string]interface{}: // Tree
+		if isLexRef(t) {
+			return parseLexRef(t["$ref"], path)
+		}
+		if isLexUnion(t) {
+			return parseLexUnion(t, path)
+		}
+		if isLexAllOf(t) {
+			return parseLexAllOf(t["allOf"], path)
+		}
+		if isLexDescription(t) {
+			return parseLexDescription(t, path)
+		}
+		return parseLexTree(t, path)
+	}
+	return nil, fmt.Errorf("schema at %s must be either a string, array or tree", pathOrRoot(path))
+}
+
+// isLexRef reports whether a JSON object in a lexicon is a reference rather
+// than the keys of a tree.
+func isLexRef(lexicon map[string]interface{}) bool {
+	_, ok := lexicon["$ref"]
+	return ok && len(lexicon) == 1
+}
+
+// parseLexRef parses a reference to a definition, "#/defs/<name>" for this
+// lexicon or "<id>#/defs/<name>" for another. "<id>" alone refers to main.
+func parseLexRef(value interface{}, path string) (*lexNode, error) {
+	ref, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("$ref at %s must be a string", pathOrRoot(path))
+	}
+	id, fragment, found := strings.Cut(ref, "#")
+	name := "main"
+	if found {
+		name, ok = strings.CutPrefix(fragment, "/defs/")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("$ref %q at %s must be of the form \"<id>#/defs/<name>\"", ref, pathOrRoot(path))
+		}
+	} else if id == "" {
+		return nil, fmt.Errorf("$ref at %s must not be empty", pathOrRoot(path))
+	}
+	return &lexNode{ref: &lexRef{id: id, name: name}}, nil
+}
+
+// isLexUnion reports whether a JSON object in a lexicon is a union rather
+// than the keys of a tree.
+func isLexUnion(lexicon map[string]interface{}) bool {
+	_, oneOf := lexicon["oneOf"]
+	_, anyOf := lexicon["anyOf"]
+	if !oneOf && !anyOf {
+		return false
+	}
+	for key := range lexicon {
+		if key != "oneOf" && key != "anyOf" && key != "discriminator" && key != "fallback" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseLexUnion parses {"oneOf":[...]} or {"anyOf":[...]}. With a
+// discriminator the branches are an object from names to schemas, and there
+// may be a fallback.
+func parseLexUnion(lexicon map[string]interface{}, path string) (*lexNode, error) {
+	keyword := "oneOf"
+	union := &lexUnion{exclusive: true}
+	if _, ok := lexicon["anyOf"]; ok {
+		if _, ok := lexicon["oneOf"]; ok {
+			return nil, fmt.Errorf("union at %s must have either oneOf or anyOf", pathOrRoot(path))
+		}
+		keyword = "anyOf"
+		union.exclusive = false
+	}
+	branchesPath := appendPath(path, keyword)
+
+	if v, ok := lexicon["discriminator"]; ok {
+		key, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("discriminator at %s must be a string", pathOrRoot(path))
+		}
+		if err := checkKey(key, path); err != nil {
+			return nil, err
+		}
+		union.discriminator = key
+		branches, ok := lexicon[keyword].(map[string]interface{})
+		if !ok || len(branches) == 0 {
+			return nil, fmt.Errorf("%s at %s must be an object of branches with a discriminator", keyword, pathOrRoot(path))
+		}
+		for name := range branches {
+			union.names = append(union.names, name)
+		}
+		sort.Strings(union.names)
+		for _, name := range union.names {
+			branch, err := parseLexNode(branches[name], appendPath(branchesPath, name))
+			if err != nil {
+				return nil, err
+			}
+			union.branches = append(union.branches, branch)
+		}
+		if v, ok := lexicon["fallback"]; ok {
+			fallback, err := parseLexNode(v, appendPath(path, "fallback"))
+			if err != nil {
+				return nil, err
+			}
+			union.fallback = fallback
+		}
+		return &lexNode{union: union}, nil
+	}
+	if _, ok := lexicon["fallback"]; ok {
+		return nil, fmt.Errorf("fallback at %s needs a discriminator", pathOrRoot(path))
+	}
+
+	branches, ok := lexicon[keyword].([]interface{})
+	if !ok || len(branches) == 0 {
+		return nil, fmt.Errorf("%s at %s must be an array of branches", keyword, pathOrRoot(path))
+	}
+	for i := range branches {
+		branch, err := parseLexNode(branches[i], appendPath(branchesPath, indexSegment(i)))
+		if err != nil {
+			return nil, err
+		}
+		union.branches = append(union.branches, branch)
+	}
+	return &lexNode{union: union}, nil
+}
+
+// parseLexParents parses the parents of a tree, a single schema or an array
+// of schemas.
+func parseLexParents(value interface{}, path string) ([]*lexNode, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		list = []interface{}{value}
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("parents at %s must not be empty", pathOrRoot(path))
+	}
+	parents := make([]*lexNode, len(list))
+	for i := range list {
+		parent, err := parseLexNode(list[i], appendPath(path, indexSegment(i)))
+		if err != nil {
+			return nil, err
+		}
+		parents[i] = parent
+	}
+	return parents, nil
+}
+
+// isLexAllOf reports whether a JSON object is {"allOf":...}.
+func isLexAllOf(m map[string]interface{}) bool {
+	_, ok := m["allOf"]
+	return ok && len(m) == 1
+}
+
+// parseLexAllOf parses {"allOf":[...]}, a tree with the keys of all the
+// trees in the array.
+func parseLexAllOf(value interface{}, path string) (*lexNode, error) {
+	if _, ok := value.([]interface{}); !ok {
+		return nil, fmt.Errorf("allOf at %s must be an array", pathOrRoot(path))
+	}
+	parents, err := parseLexParents(value, appendPath(path, "allOf"))
+	if err != nil {
+		return nil, err
+	}
+	return &lexNode{
+		kinds:   kindBit(KindTree),
+		extends: parents,
+		overlay: map[string]*lexField{},
+		path:    path,
+	}, nil
+}
+
+// kindSet returns the kinds a value matching the node may have.
+func (n *lexNode) kindSet() uint8 {
+	n = n.resolved()
+	if n.union == nil {
+		return n.kinds
+	}
+	var kinds uint8
+	for _, branch := range n.union.allBranches() {
+		kinds |= branch.kindSet()
+	}
+	return kinds
+}
+
+// allBranches returns the branches of the union followed by its fallback.
+func (u *lexUnion) allBranches() []*lexNode {
+	if u.fallback == nil {
+		return u.branches
+	}
+	return append(u.branches[:len(u.branches):len(u.branches)], u.fallback)
+}
+
+// lexKeywords are the keys of descriptions, with the kinds they apply to.
+var lexKeywords = map[string]uint8{
+	"type":           0xff,
+	"items":          kindBit(KindArray),
+	"minItems":       kindBit(KindArray),
+	"maxItems":       kindBit(KindArray),
+	"keys":           kindBit(KindTree),
+	"additionalKeys": kindBit(KindTree),
+	"values":         kindBit(KindTree),
+	"extends":        kindBit(KindTree),
+	"keyPattern":     kindBit(KindTree),
+	"minKeyLength":   kindBit(KindTree),
+	"maxKeyLength":   kindBit(KindTree),
+	"minimum":        kindBit(KindInteger),
+	"maximum":        kindBit(KindInteger),
+	"minLength":      kindBit(KindString),
+	"maxLength":      kindBit(KindString),
+	"pattern":        kindBit(KindString),
+	"format":         kindBit(KindString),
+	"minSize":        kindBit(KindBlob),
+	"maxSize":        kindBit(KindBlob),
+	"enum":           lexEnumKinds,
+	"const":          lexEnumKinds,
+}
+
+// lexEnumKinds are the kinds that enum and const apply to.
+const lexEnumKinds = 1<<KindBoolean | 1<<KindInteger | 1<<KindString
+
+// lexFormats are the formats of strings, by name.
+var lexFormats = map[string]func(string) bool{
+	"uri": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	},
+	"datetime": func(s string) bool {
+		_, err := time.Parse(time.RFC3339Nano, s)
+		return err == nil
+	},
+}
+
+// isLexDescription reports whether a JSON object in a lexicon is a
+// description of a value rather than the keys of a tree.
+func isLexDescription(lexicon map[string]interface{}) bool {
+	if _, ok := lexicon["type"]; !ok {
+		return false
+	}
+	for key := range lexicon {
+		if _, ok := lexKeywords[key]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func parseLexDescription(lexicon map[string]interface{}, path string) (*lexNode, error) {
+	names, ok := lexicon["type"].(string)
+	if !ok {
+		return nil, fmt.Errorf("type at %s must be a string", pathOrRoot(path))
+	}
+	node, err := parseLexKinds(names, path, true)
+	if err != nil {
+		return nil, err
+	}
+	if node.any && len(lexicon) > 1 {
+		return nil, fmt.Errorf("type any at %s can not have other keywords", pathOrRoot(path))
+	}
+	for key := range lexicon {
+		if lexKeywords[key]&node.kinds == 0 {
+			return nil, fmt.Errorf("%s at %s does not apply to type %q", key, pathOrRoot(path), names)
+		}
+	}
+
+	if node.accepts(KindArray) {
+		items, ok := lexicon["items"]
+		if !ok {
+			return nil, fmt.Errorf("array at %s is missing items", pathOrRoot(path))
+		}
+		node.items, err = parseLexNode(items, appendPath(path, "items"))
+		if err != nil {
+			return nil, err
+		}
+		if node.minItems, err = lexCount(lexicon, "minItems", path); err != nil {
+			return nil, err
+		}
+		if node.maxItems, err = lexCount(lexicon, "maxItems", path); err != nil {
+			return nil, err
+		}
+		if node.minItems != nil && node.maxItems != nil && *node.minItems > *node.maxItems {
+			return nil, fmt.Errorf("minItems at %s is larger than maxItems", pathOrRoot(path))
+		}
+	}
+	if node.accepts(KindTree) {
+		if err := parseLexTreeDescription(node, lexicon, names, path); err != nil {
+			return nil, err
+		}
+	}
+	if node.accepts(KindInteger) {
+		if node.minimum, err = lexInteger(lexicon, "minimum", path); err != nil {
+			return nil, err
+		}
+		if node.maximum, err = lexInteger(lexicon, "maximum", path); err != nil {
+			return nil, err
+		}
+		if node.minimum != nil && node.maximum != nil && *node.minimum > *node.maximum {
+			return nil, fmt.Errorf("minimum at %s is larger than maximum", pathOrRoot(path))
+		}
+	}
+	if node.accepts(KindString) {
+		if node.minLength, err = lexCount(lexicon, "minLength", path); err != nil {
+			return nil, err
+		}
+		if node.maxLength, err = lexCount(lexicon, "maxLength", path); err != nil {
+			return nil, err
+		}
+		if node.minLength != nil && node.maxLength != nil && *node.minLength > *node.maxLength {
+			return nil, fmt.Errorf("minLength at %s is larger than maxLength", pathOrRoot(path))
+		}
+		if v, ok := lexicon["pattern"]; ok {
+			pattern, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("pattern at %s must be a string", pathOrRoot(path))
+			}
+			if node.pattern, err = regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("pattern at %s is invalid: %w", pathOrRoot(path), err)
+			}
+		}
+		if v, ok := lexicon["format"]; ok {
+			format, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("format at %s must be a string", pathOrRoot(path))
+			}
+			if _, ok := lexFormats[format]; !ok {
+				return nil, fmt.Errorf("format %q at %s is unknown", format, pathOrRoot(path))
+			}
+			node.format = format
+		}
+	}
+	if node.accepts(KindBlob) {
+		if node.minSize, err = lexCount(lexicon, "minSize", path); err != nil {
+			return nil, err
+		}
+		if node.maxSize, err = lexCount(lexicon, "maxSize", path); err != nil {
+			return nil, err
+		}
+		if node.minSize != nil && node.maxSize != nil && *node.minSize > *node.maxSize {
+			return nil, fmt.Errorf("minSize at %s is larger than maxSize", pathOrRoot(path))
+		}
+	}
+	if node.kinds&lexEnumKinds != 0 {
+		if err := parseLexEnum(node, lexicon, path); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// parseLexEnum parses the enum or const keyword of a description.
+func parseLexEnum(node *lexNode, lexicon map[string]interface{}, path string) error {
+	values, isEnum := lexicon["enum"]
+	if v, ok := lexicon["const"]; ok {
+		if isEnum {
+			return fmt.Errorf("enum and const at %s can not be used together", pathOrRoot(path))
+		}
+		values = []interface{}{v}
+		node.isConst = true
+	} else if !isEnum {
+		return nil
+	}
+	list, ok := values.([]interface{})
+	if !ok || len(list) == 0 {
+		return fmt.Errorf("enum at %s must be a non-empty array", pathOrRoot(path))
+	}
+	for i, value := range list {
+		valuePath := appendPath(appendPath(path, "enum"), indexSegment(i))
+		if node.isConst {
+			valuePath = appendPath(path, "const")
+		}
+		var kind Kind
+		switch v := value.(type) {
+		case bool:
+			kind = KindBoolean
+		case string:
+			kind = KindString
+		case json.Number:
+			n, err := jsonInteger(v, valuePath)
+			if err != nil {
+				return err
+			}
+			value = n
+			kind = KindInteger
+		default:
+			return fmt.Errorf("value at %s must be a boolean, integer or string", valuePath)
+		}
+		if !node.accepts(kind) {
+			return fmt.Errorf("%s at %s is not allowed by type %q", kind, valuePath, kindsString(node.kinds))
+		}
+		node.enum = append(node.enum, value)
+	}
+	return nil
+}
+
+// parseLexTreeDescription parses the keywords of trees and maps. Maps are
+// trees where every key matches values.
+func parseLexTreeDescription(node *lexNode, lexicon map[string]interface{}, names string, path string) error {
+	isMap := false
+	for _, name := range strings.Split(names, "|") {
+		isMap = isMap || name == "map"
+	}
+
+	var err error
+	if isMap {
+		for _, keyword := range []string{"keys", "additionalKeys", "extends"} {
+			if _, ok := lexicon[keyword]; ok {
+				return fmt.Errorf("%s at %s does not apply to type %q", keyword, pathOrRoot(path), names)
+			}
+		}
+		values, ok := lexicon["values"]
+		if !ok {
+			return fmt.Errorf("map at %s is missing values", pathOrRoot(path))
+		}
+		if node.additional, err = parseLexNode(values, appendPath(path, "values")); err != nil {
+			return err
+		}
+		node.fields = map[string]*lexField{}
+	} else {
+		if _, ok := lexicon["values"]; ok {
+			return fmt.Errorf("values at %s does not apply to type %q", pathOrRoot(path), names)
+		}
+		keys := map[string]interface{}{}
+		if v, ok := lexicon["keys"]; ok {
+			if keys, ok = v.(map[string]interface{}); !ok {
+				return fmt.Errorf("keys at %s must be an object", pathOrRoot(path))
+			}
+		}
+		tree, err := parseLexTree(keys, path)
+		if err != nil {
+			return err
+		}
+		node.fields = tree.fields
+		if v, ok := lexicon["extends"]; ok {
+			if node.extends, err = parseLexParents(v, appendPath(path, "extends")); err != nil {
+				return err
+			}
+			node.overlay = tree.fields
+			node.fields = nil
+			node.path = path
+		}
+		switch v := lexicon["additionalKeys"].(type) {
+		case nil:
+		case bool:
+			if v {
+				node.additional = &lexNode{kinds: lexAnyKinds, any: true}
+			}
+		default:
+			if node.additional, err = parseLexNode(v, appendPath(path, "additionalKeys")); err != nil {
+				return err
+			}
+		}
+	}
+
+	if v, ok := lexicon["keyPattern"]; ok {
+		pattern, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("keyPattern at %s must be a string", pathOrRoot(path))
+		}
+		if node.keyPattern, err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("keyPattern at %s is invalid: %w", pathOrRoot(path), err)
+		}
+	}
+	for _, bound := range []struct {
+		keyword string
+		value   **int64
+	}{{"minKeyLength", &node.minKeyLength}, {"maxKeyLength", &node.maxKeyLength}} {
+		if *bound.value, err = lexInteger(lexicon, bound.keyword, path); err != nil {
+			return err
+		}
+		if *bound.value != nil && (**bound.value < 1 || **bound.value > 256) {
+			return fmt.Errorf("%s at %s must be between 1 and 256, the length of ABIT keys", bound.keyword, pathOrRoot(path))
+		}
+	}
+	if node.minKeyLength != nil && node.maxKeyLength != nil && *node.minKeyLength > *node.maxKeyLength {
+		return fmt.Errorf("minKeyLength at %s is larger than maxKeyLength", pathOrRoot(path))
+	}
+	if node.additional == nil && (node.keyPattern != nil || node.minKeyLength != nil || node.maxKeyLength != nil) {
+		return fmt.Errorf("key constraints at %s need additionalKeys", pathOrRoot(path))
+	}
+	return nil
+}
+
+// lexInteger returns the integer keyword of a description, or nil when it is
+// not set.
+func lexInteger(lexicon map[string]interface{}, keyword string, path string) (*int64, error) {
+	v, ok := lexicon[keyword]
+	if !ok {
+		return nil, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s at %s must be an integer", keyword, pathOrRoot(path))
+	}
+	i, err := jsonInteger(n, appendPath(path, keyword))
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// lexCount returns a non-negative integer keyword of a description.
+func lexCount(lexicon map[string]interface{}, keyword string, path string) (*int64, error) {
+	i, err := lexInteger(lexicon, keyword, path)
+	if i != nil && *i < 0 {
+		return nil, fmt.Errorf("%s at %s must not be negative", keyword, pathOrRoot(path))
+	}
+	return i, err
+}
+
+// parseLexKinds parses type names separated by "|". "array" and "tree" are
+// only allowed in descriptions.
+func parseLexKinds(names string, path string, containers bool) (*lexNode, error) {
+	node := &lexNode{}
+	for _, name := range strings.Split(names, "|") {
+		switch name {
+		case "null":
+			node.kinds |= kindBit(KindNull)
+		case "boolean":
+			node.kinds |= kindBit(KindBoolean)
+		case "integer":
+			node.kinds |= kindBit(KindInteger)
+		case "blob":
+			node.kinds |= kindBit(KindBlob)
+		case "string":
+			node.kinds |= kindBit(KindString)
+		case "array":
+			if !containers {
+				return nil, fmt.Errorf("array at %s must be a tuple or described with {\"type\":\"array\"}", pathOrRoot(path))
+			}
+			node.kinds |= kindBit(KindArray)
+		case "tree", "map":
+			if !containers {
+				return nil, fmt.Errorf("%s at %s must be an object or described with {\"type\":\"%s\"}", name, pathOrRoot(path), name)
+			}
+			if node.accepts(KindTree) {
+				return nil, fmt.Errorf("type %q at %s has more than one tree or map", names, pathOrRoot(path))
+			}
+			node.kinds |= kindBit(KindTree)
+		case "any":
+			if names != "any" {
+				return nil, fmt.Errorf("type any at %s can not be combined with other types", pathOrRoot(path))
+			}
+			node.kinds = lexAnyKinds
+			node.any = true
+		default:
+			return nil, fmt.Errorf("type %q at %s must be any of: \"null\", \"boolean\", \"integer\", \"blob\", \"string\", \"any\"", name, pathOrRoot(path))
+		}
+	}
+	return node, nil
+}
+
+func parseLexTuple(lexicon []interface{}, path string) (*lexNode, error) {
+	node := &lexNode{
+		kinds: kindBit(KindArray),
+		tuple: make([]*lexNode, len(lexicon)),
+	}
+	for i := range lexicon {
+		item, err := parseLexNode(lexicon[i], appendPath(path, indexSegment(i)))
+		if err != nil {
+			return nil, err
+		}
+		node.tuple[i] = item
+	}
+	return node, nil
+}
+
+func parseLexTree(lexicon map[string]interface{}, path string) (*lexNode, error) {
+	node := &lexNode{
+		kinds:  kindBit(KindTree),
+		fields: map[string]*lexField{},
+	}
+	for key, value := range lexicon {
+		field := &lexField{}
+		if strings.HasSuffix(key, "?") {
+			key = strings.TrimSuffix(key, "?")
+			field.optional = true
+		}
+		if err := checkKey(key, path); err != nil {
+			return nil, err
+		}
+		if _, ok := node.fields[key]; ok {
+			return nil, fmt.Errorf("duplicate key %q at %s", key, pathOrRoot(path))
+		}
+		child, err := parseLexNode(value, appendPath(path, key))
+		if err != nil {
+			return nil, err
+		}
+		field.node = child
+		node.fields[key] = field
+	}
+	return node, nil
+}
+
+// children returns the schemas nested directly in the node, without
+// following references.
+func (n *lexNode) children() []*lexNode {
+	var children []*lexNode
+	fields := n.ownFields(false)
+	for _, key := range sortedFieldKeys(fields) {
+		children = append(children, fields[key].node)
+	}
+	children = append(children, n.extends...)
+	children = append(children, n.tuple...)
+	if n.items != nil {
+		children = append(children, n.items)
+	}
+	if n.additional != nil {
+		children = append(children, n.additional)
+	}
+	if n.union != nil {
+		children = append(children, n.union.allBranches()...)
+	}
+	return children
+}
+
+// ownFields returns the keys written in the tree itself, or with flat the
+// keys including those of its parents.
+func (n *lexNode) ownFields(flat bool) map[string]*lexField {
+	if n.extends != nil && !flat {
+		return n.overlay
+	}
+	return n.fields
+}
+
+// walk calls fn for the node and every schema nested in it.
+func (n *lexNode) walk(fn func(*lexNode)) {
+	fn(n)
+	for _, child := range n.children() {
+		child.walk(fn)
+	}
+}
+
+// resolved follows references to the schema they lead to. If a reference is
+// not resolved, it is returned instead.
+func (n *lexNode) resolved() *lexNode {
+	for n.ref != nil && n.ref.target != nil {
+		n = n.ref.target
+	}
+	return n
+}
+
+// link resolves the references of the lexicon. lookup finds other lexicons
+// by id, references to other lexicons are left unresolved if lookup is nil.
+// Nothing is resolved if an error is returned.
+func (l *ABITLexicon) link(lookup func(id string) *ABITLexicon) error {
+	var refs []*lexRef
+	var err error
+	for _, name := range sortedDefNames(l.defs) {
+		l.defs[name].walk(func(n *lexNode) {
+			if err != nil || n.ref == nil {
+				return
+			}
+			n.ref.scope = l.id
+			if n.ref.target != nil {
+				return
+			}
+			target := l
+			if n.ref.id != "" && n.ref.id != l.id {
+				if lookup == nil {
+					return
+				}
+				if target = lookup(n.ref.id); target == nil {
+					err = fmt.Errorf("lexicon %q referenced at defs.%s is unknown", n.ref.id, name)
+					return
+				}
+			}
+			if _, ok := target.defs[n.ref.name]; !ok {
+				err = fmt.Errorf("definition %s referenced at defs.%s does not exist", n.ref, name)
+				return
+			}
+			n.ref.target = target.defs[n.ref.name]
+			refs = append(refs, n.ref)
+		})
+	}
+	if err == nil {
+		err = l.checkCycles()
+	}
+	if err != nil {
+		for _, ref := range refs {
+			ref.target = nil
+		}
+	}
+	return err
+}
+
+// unlink removes the resolved references to other lexicons, and the parents
+// composed through them.
+func (l *ABITLexicon) unlink() {
+	for _, def := range l.defs {
+		def.walk(func(n *lexNode) {
+			if n.ref != nil && n.ref.id != "" && n.ref.id != l.id {
+				n.ref.target = nil
+			}
+			if n.extends != nil {
+				n.fields = nil
+			}
+		})
+	}
+	// Parents in the lexicon itself were composed before.
+	_ = l.compose(false)
+}
+
+// errUnresolvedParent is returned when composing a tree with a parent that
+// is a reference to another lexicon, which is not resolved.
+var errUnresolvedParent = errors.New("parent is not resolved")
+
+// compose merges the parents of the trees with extends in the lexicon into
+// their fields. Unless strict is set, trees with parents that are not
+// resolved are left as they are.
+func (l *ABITLexicon) compose(strict bool) error {
+	for _, name := range sortedDefNames(l.defs) {
+		var err error
+		l.defs[name].walk(func(n *lexNode) {
+			if err == nil && n.extends != nil {
+				err = n.compose(map[*lexNode]bool{})
+				if !strict && errors.Is(err, errUnresolvedParent) {
+					err = nil
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compose sets the fields of a tree with extends to the keys of its parents
+// and its overlay. Two parents must not have the same key with different
+// schemas, and the overlay may only narrow the schemas of inherited keys:
+// every value it accepts for a key must match the schema of the parent.
+func (n *lexNode) compose(visiting map[*lexNode]bool) error {
+	if n.fields != nil {
+		return nil
+	}
+	if visiting[n] {
+		return fmt.Errorf("tree at %s extends itself", pathOrRoot(n.path))
+	}
+	visiting[n] = true
+	defer delete(visiting, n)
+
+	fields := map[string]*lexField{}
+	for i, parent := range n.extends {
+		parent = parent.resolved()
+		if parent.ref != nil {
+			return fmt.Errorf("%w: %s at %s", errUnresolvedParent, parent.ref, pathOrRoot(n.path))
+		}
+		if parent.union != nil || parent.any || !parent.accepts(KindTree) {
+			return fmt.Errorf("parent %d of tree at %s must be a tree", i, pathOrRoot(n.path))
+		}
+		if err := parent.compose(visiting); err != nil {
+			return err
+		}
+		for key, field := range parent.fields {
+			if inherited, ok := fields[key]; ok && !sameLexField(inherited, field) {
+				return fmt.Errorf("key %q at %s is defined differently by two parents", key, pathOrRoot(n.path))
+			}
+			fields[key] = field
+		}
+	}
+	for key, field := range n.overlay {
+		if inherited, ok := fields[key]; ok {
+			narrower := !field.optional || inherited.optional
+			if narrower && !sameLexField(field, inherited) {
+				var err error
+				c := &lexNarrowing{visiting: visiting, assumed: map[[2]*lexNode]bool{}}
+				narrower, err = c.narrows(field.node, inherited.node)
+				if err != nil {
+					return err
+				}
+			}
+			if !narrower {
+				return fmt.Errorf("key %q at %s allows more than its parent", key, pathOrRoot(n.path))
+			}
+		}
+		fields[key] = field
+	}
+	n.fields = fields
+	return nil
+}
+
+// lexNarrowing compares the schemas of inherited keys with the schemas an
+// overlay replaces them with.
+type lexNarrowing struct {
+	// visiting holds the trees being composed.
+	visiting map[*lexNode]bool
+	// assumed holds the pairs of schemas being compared, which are assumed
+	// to narrow when a schema leads back to itself.
+	assumed map[[2]*lexNode]bool
+}
+
+// narrows reports whether every value matching n also matches parent. It
+// only looks at the schemas, so it reports false for some schemas that do
+// narrow parent, such as a pattern replaced by a stricter one.
+func (c *lexNarrowing) narrows(n, parent *lexNode) (bool, error) {
+	n, parent = n.resolved(), parent.resolved()
+	if n == parent || parent.any {
+		return true, nil
+	}
+	for _, node := range []*lexNode{n, parent} {
+		if node.ref != nil {
+			return false, fmt.Errorf("%w: %s at %s", errUnresolvedParent, node.ref, pathOrRoot(node.path))
+		}
+	}
+	pair := [2]*lexNode{n, parent}
+	if c.assumed[pair] {
+		return true, nil
+	}
+	c.assumed[pair] = true
+	defer delete(c.assumed, pair)
+	if n.any {
+		return false, nil
+	}
+
+	if n.union != nil {
+		return c.unionNarrows(n.union, parent)
+	}
+	if parent.union != nil {
+		// A value matching a branch of oneOf may match another branch too,
+		// and trees need the discriminator to match a named branch.
+		if parent.union.exclusive || parent.union.discriminator != "" {
+			return false, nil
+		}
+		for _, branch := range parent.union.branches {
+			ok, err := c.narrows(n, branch)
+			if ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+
+	if n.kinds&^parent.kinds != 0 {
+		return false, nil
+	}
+	if parent.enum != nil && n.kinds&(kindBit(KindBoolean)|kindBit(KindInteger)|kindBit(KindString)) != 0 {
+		if n.enum == nil || !enumSubset(n.enum, parent.enum) {
+			return false, nil
+		}
+	}
+	if n.accepts(KindInteger) && !narrowsBounds(n.minimum, n.maximum, parent.minimum, parent.maximum) {
+		return false, nil
+	}
+	if n.accepts(KindBlob) && !narrowsBounds(n.minSize, n.maxSize, parent.minSize, parent.maxSize) {
+		return false, nil
+	}
+	if n.accepts(KindString) {
+		if !narrowsBounds(n.minLength, n.maxLength, parent.minLength, parent.maxLength) ||
+			!samePattern(n.pattern, parent.pattern) || (parent.format != "" && n.format != parent.format) {
+			return false, nil
+		}
+	}
+	if n.accepts(KindArray) {
+		if ok, err := c.arrayNarrows(n, parent); !ok || err != nil {
+			return ok, err
+		}
+	}
+	if n.accepts(KindTree) {
+		return c.treeNarrows(n, parent)
+	}
+	return true, nil
+}
+
+// unionNarrows reports whether every value matching a branch of union also
+// matches parent.
+func (c *lexNarrowing) unionNarrows(union *lexUnion, parent *lexNode) (bool, error) {
+	if union.discriminator == "" {
+		for _, branch := range union.branches {
+			if ok, err := c.narrows(branch, parent); !ok || err != nil {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	// The discriminator is not a key of the branches, so they are compared
+	// with the branches of the same name.
+	if parent.union == nil || parent.union.discriminator != union.discriminator {
+		return false, nil
+	}
+	branches := make(map[string]*lexNode, len(parent.union.names))
+	for i, name := range parent.union.names {
+		branches[name] = parent.union.branches[i]
+	}
+	for i, name := range union.names {
+		branch, ok := branches[name]
+		if !ok {
+			branch = parent.union.fallback
+		}
+		if branch == nil {
+			return false, nil
+		}
+		if ok, err := c.narrows(union.branches[i], branch); !ok || err != nil {
+			return ok, err
+		}
+	}
+	if union.fallback == nil {
+		return true, nil
+	}
+	// The fallback also matches the trees naming branches of parent that
+	// union has no branch for.
+	if parent.union.fallback == nil {
+		return false, nil
+	}
+	named := make(map[string]bool, len(union.names))
+	for _, name := range union.names {
+		named[name] = true
+	}
+	for i, name := range parent.union.names {
+		if named[name] {
+			continue
+		}
+		if ok, err := c.narrows(union.fallback, parent.union.branches[i]); !ok || err != nil {
+			return ok, err
+		}
+	}
+	return c.narrows(union.fallback, parent.union.fallback)
+}
+
+func (c *lexNarrowing) arrayNarrows(n, parent *lexNode) (bool, error) {
+	if parent.items == nil {
+		if n.items != nil || len(n.tuple) != len(parent.tuple) {
+			return false, nil
+		}
+		for i, item := range n.tuple {
+			if ok, err := c.narrows(item, parent.tuple[i]); !ok || err != nil {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	if n.items == nil {
+		count := int64(len(n.tuple))
+		if !inBounds(count, parent.minItems, parent.maxItems) {
+			return false, nil
+		}
+		for _, item := range n.tuple {
+			if ok, err := c.narrows(item, parent.items); !ok || err != nil {
+				return ok, err
+			}
+		}
+		return true, nil
+	}
+	if !narrowsBounds(n.minItems, n.maxItems, parent.minItems, parent.maxItems) {
+		return false, nil
+	}
+	return c.narrows(n.items, parent.items)
+}
+
+func (c *lexNarrowing) treeNarrows(n, parent *lexNode) (bool, error) {
+	for _, node := range []*lexNode{n, parent} {
+		if node.extends != nil {
+			if err := node.compose(c.visiting); err != nil {
+				return false, err
+			}
+		}
+	}
+	for key, inherited := range parent.fields {
+		field, ok := n.fields[key]
+		if !ok {
+			// The key is left out of n, which is only narrower if n does
+			// not accept it as an additional key.
+			if !inherited.optional || n.additional != nil {
+				return false, nil
+			}
+			continue
+		}
+		if field.optional && !inherited.optional {
+			return false, nil
+		}
+		if ok, err := c.narrows(field.node, inherited.node); !ok || err != nil {
+			return ok, err
+		}
+	}
+	for key, field := range n.fields {
+		if _, ok := parent.fields[key]; ok {
+			continue
+		}
+		if parent.additional == nil || !acceptsKey(parent, key) {
+			return false, nil
+		}
+		if ok, err := c.narrows(field.node, parent.additional); !ok || err != nil {
+			return ok, err
+		}
+	}
+	if n.additional == nil {
+		return true, nil
+	}
+	if parent.additional == nil || !narrowsBounds(n.minKeyLength, n.maxKeyLength, parent.minKeyLength, parent.maxKeyLength) ||
+		!samePattern(n.keyPattern, parent.keyPattern) {
+		return false, nil
+	}
+	return c.narrows(n.additional, parent.additional)
+}
+
+// narrowsBounds reports whether the bounds min and max are within the
+// bounds parentMin and parentMax, where nil is no bound.
+func narrowsBounds(min, max, parentMin, parentMax *int64) bool {
+	return (parentMin == nil || min != nil && *min >= *parentMin) &&
+		(parentMax == nil || max != nil && *max <= *parentMax)
+}
+
+// samePattern reports whether pattern keeps the constraint of parent, which
+// it only does if it is the same expression.
+func samePattern(pattern, parent *regexp.Regexp) bool {
+	return parent == nil || pattern != nil && pattern.String() == parent.String()
+}
+
+// enumSubset reports whether every value of enum is in parent.
+func enumSubset(enum, parent []interface{}) bool {
+	for _, value := range enum {
+		found := false
+		for _, allowed := range parent {
+			if value == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// acceptsKey reports whether key follows the key constraints of the tree n.
+func acceptsKey(n *lexNode, key string) bool {
+	return inBounds(int64(len(key)), n.minKeyLength, n.maxKeyLength) &&
+		(n.keyPattern == nil || n.keyPattern.MatchString(key))
+}
+
+// sameLexField reports whether two keys of trees have the same schema.
+func sameLexField(a, b *lexField) bool {
+	if a.optional != b.optional {
+		return false
+	}
+	if a.node.resolved() == b.node.resolved() {
+		return true
+	}
+	return reflect.DeepEqual(a.node.resolved().source(true), b.node.resolved().source(true))
+}
+
+// checkCycles returns an error if a schema of the lexicon leads back to
+// itself through references or unions without a tree or array in between,
+// as matching it would never end.
+func (l *ABITLexicon) checkCycles() error {
+	const visiting, done = 1, 2
+	state := map[*lexNode]uint8{}
+	var visit func(n *lexNode) bool
+	visit = func(n *lexNode) bool {
+		switch state[n] {
+		case visiting:
+			return false
+		case done:
+			return true
+		}
+		state[n] = visiting
+		for _, alias := range n.aliases() {
+			if !visit(alias) {
+				return false
+			}
+		}
+		state[n] = done
+		return true
+	}
+	for _, name := range sortedDefNames(l.defs) {
+		ok := true
+		l.defs[name].walk(func(n *lexNode) {
+			ok = ok && visit(n)
+		})
+		if !ok {
+			return fmt.Errorf("schema at defs.%s leads back to itself without a tree or array in between", name)
+		}
+	}
+	return nil
+}
+
+// aliases returns the schemas a value of the node is matched against in its
+// place, the target of a reference or the branches of a union.
+func (n *lexNode) aliases() []*lexNode {
+	if n.ref != nil && n.ref.target != nil {
+		return []*lexNode{n.ref.target}
+	}
+	if n.union != nil {
+		return n.union.allBranches()
+	}
+	return nil
+}
+
+// sortedFieldKeys returns the keys of a lexicon tree in canonical order.
+func sortedFieldKeys(fields map[string]*lexField) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyCompare(keys[i], keys[j]) })
+	return keys
+}
+
+// sortedDefNames returns the names of definitions in canonical order.
+func sortedDefNames(defs map[string]*lexNode) []string {
+	names := make([]string, 0, len(defs))
+	for name := range defs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return keyCompare(names[i], names[j]) })
+	return names
+}
@@ -0,0 +1,348 @@
+package abit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLexiconOptional(t *testing.T) {
+	lex := InitLexicon(`{
+		"name": "string",
+		"nickname?": "string|null",
+		"age?": "integer",
+		"profile?": {
+			"bio": "string|null",
+			"links?": ["string", "blob|string"]
+		}
+	}`)
+
+	matching := []func(tree *ABITObject){
+		func(tree *ABITObject) {},
+		func(tree *ABITObject) { tree.Put("nickname", "fluffy") },
+		func(tree *ABITObject) { tree.Put("nickname", Null{}) },
+		func(tree *ABITObject) { tree.Put("age", int64(3)) },
+		func(tree *ABITObject) {
+			profile, _ := NewABITObject(&[]byte{})
+			profile.Put("bio", Null{})
+			tree.Put("profile", *profile)
+		},
+		func(tree *ABITObject) {
+			profile, _ := NewABITObject(&[]byte{})
+			profile.Put("bio", "meow")
+			links := NewABITArray()
+			links.Add("a")
+			links.Add([]byte{1})
+			profile.Put("links", *links)
+			tree.Put("profile", *profile)
+		},
+	}
+	for i, edit := range matching {
+		tree, _ := NewABITObject(&[]byte{})
+		tree.Put("name", "cat")
+		edit(tree)
+		if !lex.Matches(tree) {
+			t.Fatalf("case %d doesn't match when should", i)
+		}
+	}
+
+	failing := []func(tree *ABITObject){
+		func(tree *ABITObject) { tree.Remove("name") },
+		func(tree *ABITObject) { tree.Put("name", Null{}) },
+		func(tree *ABITObject) { tree.Put("age", Null{}) },
+		func(tree *ABITObject) { tree.Put("nickname", int64(1)) },
+		func(tree *ABITObject) { tree.Put("nickname?", "fluffy") },
+		func(tree *ABITObject) { tree.Put("extra", "key") },
+		func(tree *ABITObject) {
+			profile, _ := NewABITObject(&[]byte{})
+			tree.Put("profile", *profile)
+		},
+		func(tree *ABITObject) {
+			profile, _ := NewABITObject(&[]byte{})
+			profile.Put("bio", "meow")
+			links := NewABITArray()
+			links.Add("a")
+			profile.Put("links", *links)
+			tree.Put("profile", *profile)
+		},
+	}
+	for i, edit := range failing {
+		tree, _ := NewABITObject(&[]byte{})
+		tree.Put("name", "cat")
+		edit(tree)
+		if lex.Matches(tree) {
+			t.Fatalf("case %d matches when shouldn't", i)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"a":"string|"}`,
+		`{"a":"string|tree"}`,
+		`{"?":"string"}`,
+		`{"a":"string","a?":"string"}`,
+		`{"a":1}`,
+		`["string"]`,
+	} {
+		shouldPanic(t, func() { InitLexicon(invalid) })
+	}
+}
+
+func TestLexiconBaseline(t *testing.T) {
+	lex := InitLexicon(`{"post": {"type": "string", "format": "string"}}`)
+
+	post, _ := NewABITObject(&[]byte{})
+	post.Put("type", "a")
+	post.Put("format", "b")
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("post", *post)
+	if !lex.Matches(tree) {
+		t.Fatal("keys named like description keywords must stay keys without \"$lexicon\"")
+	}
+	tree.Put("post", "a")
+	if lex.Matches(tree) {
+		t.Fatal("tree with a \"type\" key accepted a string")
+	}
+
+	single := InitLexicon(`{"post": {"type": "string"}}`)
+	parsed, err := ParseLexicon(single.ToByteArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Remove("format")
+	tree.Put("post", *post)
+	if !parsed.Matches(tree) {
+		t.Fatal("tree with a \"type\" key changed after encoding and parsing")
+	}
+
+	for _, invalid := range []string{
+		`{"post": {"type": "string", "minLength": 1}}`,
+		`{"$lexicon": 2, "a": "string"}`,
+		`{"$lexicon": 1.0, "a": "string"}`,
+	} {
+		shouldPanic(t, func() { InitLexicon(invalid) })
+	}
+	for lexicon, hint := range map[string]string{
+		`{"c": {"$ref": "#/defs/comment"}}`:                                `key c.$ref is only a keyword in lexicons with "$lexicon":1 at the root`,
+		`{"tags": {"type": "array", "items": "string", "minItems": 1}}`:    `key tags.type is only a keyword in lexicons with "$lexicon":1 at the root`,
+		`{"defs": {"main": {"a": [{"oneOf": ["string", "integer"]}, 1]}}}`: `key defs is only a keyword in lexicons with "$lexicon":1 at the root`,
+		`{"a": 1}`: "",
+	} {
+		_, err := ParseLexiconJSON([]byte(lexicon))
+		if err == nil || strings.Contains(err.Error(), "$lexicon") != (hint != "") || !strings.HasSuffix(err.Error(), hint) {
+			t.Fatalf("lexicon %s gave error %v, want hint %q", lexicon, err, hint)
+		}
+	}
+}
+
+func TestLexiconList(t *testing.T) {
+	lex := InitLexicon(`{
+		"$lexicon": 1,
+		"tags": {"type": "array", "items": "string", "minItems": 1, "maxItems": 3},
+		"points?": {"type": "array|null", "items": ["integer", "integer"]},
+		"meta?": {"type": "tree", "keys": {"type": "string", "items?": "integer"}}
+	}`)
+
+	list := func(values ...interface{}) ABITArray {
+		arr := NewABITArray()
+		for _, v := range values {
+			arr.Add(v)
+		}
+		return *arr
+	}
+	point := list(int64(1), int64(2))
+
+	cases := []struct {
+		values  map[string]interface{}
+		matches bool
+	}{
+		{map[string]interface{}{"tags": list("a")}, true},
+		{map[string]interface{}{"tags": list("a", "b", "c")}, true},
+		{map[string]interface{}{"tags": list()}, false},
+		{map[string]interface{}{"tags": list("a", "b", "c", "d")}, false},
+		{map[string]interface{}{"tags": list("a", int64(1))}, false},
+		{map[string]interface{}{"tags": list("a"), "points": Null{}}, true},
+		{map[string]interface{}{"tags": list("a"), "points": list()}, true},
+		{map[string]interface{}{"tags": list("a"), "points": list(point, point)}, true},
+		{map[string]interface{}{"tags": list("a"), "points": list(point, list(int64(1)))}, false},
+		{map[string]interface{}{"tags": list("a"), "meta": map[string]interface{}{"type": "x"}}, true},
+		{map[string]interface{}{"tags": list("a"), "meta": map[string]interface{}{"type": "x", "items": int64(1)}}, true},
+		{map[string]interface{}{"tags": list("a"), "meta": map[string]interface{}{"items": int64(1)}}, false},
+	}
+	for i, c := range cases {
+		tree, _ := NewABITObject(&[]byte{})
+		for key, value := range c.values {
+			if m, ok := value.(map[string]interface{}); ok {
+				nested, _ := NewABITObject(&[]byte{})
+				for k, v := range m {
+					nested.Put(k, v)
+				}
+				value = *nested
+			}
+			tree.Put(key, value)
+		}
+		if lex.Matches(tree) != c.matches {
+			t.Fatalf("case %d: expected match to be %t", i, c.matches)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"$lexicon": 1, "a":{"type":"array"}}`,
+		`{"$lexicon": 1, "a":{"type":"array","items":"string","minItems":-1}}`,
+		`{"$lexicon": 1, "a":{"type":"array","items":"string","minItems":2,"maxItems":1}}`,
+		`{"$lexicon": 1, "a":{"type":"array","items":"string","maxItems":1.5}}`,
+		`{"$lexicon": 1, "a":{"type":"array","items":"string","maxItems":"1"}}`,
+		`{"$lexicon": 1, "a":{"type":"string","items":"string"}}`,
+		`{"$lexicon": 1, "a":{"type":"tree","keys":"string"}}`,
+		`{"$lexicon": 1, "a":{"type":1}}`,
+		`{"$lexicon": 1, "a":"array"}`,
+		`{"$lexicon": 1, "a":"tree"}`,
+	} {
+		shouldPanic(t, func() { InitLexicon(invalid) })
+	}
+}
+
+func TestLexiconConstraints(t *testing.T) {
+	lex := InitLexicon(`{
+		"$lexicon": 1,
+		"age?": {"type": "integer", "minimum": 0, "maximum": 150},
+		"name?": {"type": "string|null", "minLength": 1, "maxLength": 4, "pattern": "^[a-zä]+$"},
+		"site?": {"type": "string", "format": "uri"},
+		"at?": {"type": "string", "format": "datetime"},
+		"avatar?": {"type": "blob|integer", "minSize": 1, "maxSize": 2, "maximum": 9}
+	}`)
+
+	cases := []struct {
+		key     string
+		value   interface{}
+		matches bool
+	}{
+		{"age", int64(0), true},
+		{"age", int64(150), true},
+		{"age", int64(-1), false},
+		{"age", int64(151), false},
+		{"name", "cat", true},
+		{"name", "päär", false},
+		{"name", "pär", true},
+		{"name", Null{}, true},
+		{"name", "", false},
+		{"name", "Cat", false},
+		{"site", "https://example.com/a", true},
+		{"site", "example.com", false},
+		{"at", "2024-02-29T12:00:00.5Z", true},
+		{"at", "2024-02-29", false},
+		{"avatar", []byte{1}, true},
+		{"avatar", []byte{}, false},
+		{"avatar", []byte{1, 2, 3}, false},
+		{"avatar", int64(9), true},
+		{"avatar", int64(10), false},
+	}
+	for i, c := range cases {
+		tree, _ := NewABITObject(&[]byte{})
+		tree.Put(c.key, c.value)
+		if lex.Matches(tree) != c.matches {
+			t.Fatalf("case %d: expected match to be %t", i, c.matches)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"$lexicon": 1, "a":{"type":"integer","minimum":2,"maximum":1}}`,
+		`{"$lexicon": 1, "a":{"type":"integer","minimum":"1"}}`,
+		`{"$lexicon": 1, "a":{"type":"string","minLength":-1}}`,
+		`{"$lexicon": 1, "a":{"type":"string","pattern":"("}}`,
+		`{"$lexicon": 1, "a":{"type":"string","pattern":1}}`,
+		`{"$lexicon": 1, "a":{"type":"string","format":"color"}}`,
+		`{"$lexicon": 1, "a":{"type":"string","minSize":1}}`,
+		`{"$lexicon": 1, "a":{"type":"blob","maxLength":1}}`,
+		`{"$lexicon": 1, "a":{"type":"blob","minSize":3,"maxSize":2}}`,
+	} {
+		shouldPanic(t, func() { InitLexicon(invalid) })
+	}
+}
+
+func TestLexiconZero(t *testing.T) {
+	var lex ABITLexicon
+	empty, _ := NewABITObject(&[]byte{})
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("a", int64(1))
+	if !lex.Matches(empty) || lex.Matches(tree) {
+		t.Fatal("zero lexicon must only match empty trees")
+	}
+	if err := lex.Compile().ValidateBytes(empty.ToByteArray()); err != nil {
+		t.Fatal(err)
+	}
+	if lex.Compile().ValidateBytes(tree.ToByteArray()) == nil {
+		t.Fatal("zero lexicon accepted encoded tree with a key")
+	}
+	if string(lex.ToJSON()) != `{"$lexicon":1}` {
+		t.Fatalf("unexpected source %s", lex.ToJSON())
+	}
+	parsed, err := ParseLexicon(lex.ToByteArray())
+	if err != nil || !parsed.Matches(empty) || parsed.Matches(tree) {
+		t.Fatalf("zero lexicon changed after encoding and parsing: %v", err)
+	}
+	if lex.WithContentID().ContentID() != lex.ContentID() {
+		t.Fatal("content id of zero lexicon changed")
+	}
+}
+
+func parseTestLexicon(t *testing.T, lexicon string) *ABITLexicon {
+	t.Helper()
+	lex, err := ParseLexiconJSON([]byte(lexicon))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lex
+}
+
+// lexiconCase is a tree with the single key key holding value, and the
+// errors Validate should return for it.
+type lexiconCase struct {
+	key    string
+	value  interface{}
+	errors []ValidationError
+}
+
+func checkValidation(t *testing.T, lex *ABITLexicon, cases []lexiconCase) {
+	t.Helper()
+	for i, c := range cases {
+		doc := testTree(map[string]interface{}{c.key: c.value})
+		errs := lex.Validate(&doc)
+		if len(errs) != len(c.errors) {
+			t.Fatalf("case %d: expected %d errors, got %v", i, len(c.errors), errs)
+		}
+		for j := range errs {
+			if errs[j] != c.errors[j] {
+				t.Fatalf("case %d: error is %#v, expected %#v", i, errs[j], c.errors[j])
+			}
+		}
+	}
+}
+
+func checkLexiconRoundTrip(t *testing.T, lex *ABITLexicon) {
+	t.Helper()
+	parsed, err := ParseLexicon(lex.ToByteArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.ToByteArray(), lex.ToByteArray()) {
+		t.Fatal("lexicon changed after encoding and parsing")
+	}
+}
+
+func checkInvalidLexicons(t *testing.T, lexicons []string) {
+	t.Helper()
+	for _, invalid := range lexicons {
+		if _, err := ParseLexiconJSON([]byte(invalid)); err == nil {
+			t.Fatalf("invalid lexicon %s parsed", invalid)
+		}
+	}
+}
+
+// testTree returns a tree with the given keys and values.
+func testTree(values map[string]interface{}) ABITObject {
+	tree, _ := NewABITObject(&[]byte{})
+	for key, value := range values {
+		tree.Put(key, value)
+	}
+	return *tree
+}
@@ -0,0 +1,422 @@
+package abit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+)
+
+// ParseLexicon creates an ABITLexicon from a lexicon encoded as an ABIT
+// document, such as one written by ABITLexicon.ToByteArray.
+//
+// The document is the JSON format described at InitLexicon written in ABIT:
+// JSON objects are trees, arrays are arrays, strings are strings and numbers
+// are integers.
+//
+// error is nil on success or describes why the lexicon is invalid.
+func ParseLexicon(doc []byte) (*ABITLexicon, error) {
+	tree, err := NewABITObject(&doc)
+	if err != nil {
+		return nil, err
+	}
+	value, err := abitToLexSource(tree, "")
+	if err != nil {
+		return nil, err
+	}
+	return parseLexicon(value)
+}
+
+// LoadLexiconFS reads a lexicon from a file in fsys, such as an embed.FS.
+// Files ending with ".json" are read with ParseLexiconJSON, all other files
+// with ParseLexicon.
+//
+// # Example
+//
+//	//go:embed lexicons
+//	var lexicons embed.FS
+//
+//	lex, err := abit.LoadLexiconFS(lexicons, "lexicons/post.json")
+func LoadLexiconFS(fsys fs.FS, name string) (*ABITLexicon, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	return loadLexicon(name, data)
+}
+
+// LoadLexiconFile reads a lexicon from the file at name, in the same way as
+// LoadLexiconFS.
+func LoadLexiconFile(name string) (*ABITLexicon, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return loadLexicon(name, data)
+}
+
+func loadLexicon(name string, data []byte) (*ABITLexicon, error) {
+	var lex *ABITLexicon
+	var err error
+	if path.Ext(name) == ".json" {
+		lex, err = ParseLexiconJSON(data)
+	} else {
+		lex, err = ParseLexicon(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("lexicon %s: %w", name, err)
+	}
+	return lex, nil
+}
+
+// ToByteArray encodes the lexicon as an ABIT document that can be read back
+// with ParseLexicon. Lexicons with the same schema have the same encoding.
+func (l *ABITLexicon) ToByteArray() []byte {
+	tree, err := lexSourceToABIT(l.source(false), "")
+	if err != nil {
+		// Sources are built from parsed lexicons, which only hold valid keys
+		// and integers.
+		panic(err.Error())
+	}
+	obj := tree.(ABITObject)
+	return obj.ToByteArray()
+}
+
+// contentIDPrefix starts the ids returned by ContentID.
+const contentIDPrefix = "sha256:"
+
+// ContentID returns an id derived from the schema of the lexicon, "sha256:"
+// followed by the hex SHA-256 hash of the ToByteArray encoding of the
+// lexicon without its id. Lexicons with the same schema have the same
+// ContentID, whatever their id.
+//
+// References to other lexicons are hashed as their ids, not their schemas, so
+// the ContentID only fixes the schemas of referenced lexicons when they are
+// referenced by their ContentID too. Registry.Add requires this of lexicons
+// with a content id.
+func (l *ABITLexicon) ContentID() string {
+	anonymous := *l
+	anonymous.id = ""
+	sum := sha256.Sum256(anonymous.ToByteArray())
+	return contentIDPrefix + hex.EncodeToString(sum[:])
+}
+
+// WithContentID returns a copy of the lexicon with its ContentID as id. The
+// copy is not in a registry.
+//
+// # Example
+//
+//	lex = lex.WithContentID()
+//	err := reg.Add(lex)
+//	if err != nil {
+//		// Handle missing lexicons or definitions here
+//	}
+func (l *ABITLexicon) WithContentID() *ABITLexicon {
+	defs := map[string]interface{}{"main": map[string]interface{}{}}
+	for name, def := range l.defs {
+		defs[name] = def.source(false)
+	}
+	lex, err := parseLexicon(map[string]interface{}{
+		lexVersionKey: json.Number(strconv.Itoa(lexVersion)),
+		"id":          l.ContentID(),
+		"defs":        defs,
+	})
+	if err != nil {
+		// The definitions were parsed before.
+		panic(err.Error())
+	}
+	return lex
+}
+
+// ToJSON returns the lexicon in the JSON format read by ParseLexiconJSON,
+// with the keys of objects sorted.
+func (l *ABITLexicon) ToJSON() []byte {
+	data, err := json.Marshal(l.source(false))
+	if err != nil {
+		// Sources only hold JSON values.
+		panic(err.Error())
+	}
+	return data
+}
+
+// Flatten returns the lexicon with the keys of the parents of trees with
+// extends or allOf copied into the trees, so it no longer depends on them.
+// References to definitions are kept, and are resolved in the registry of l.
+//
+// error is not nil if a parent is in a lexicon that is not resolved.
+func (l *ABITLexicon) Flatten() (*ABITLexicon, error) {
+	if err := l.compose(true); err != nil {
+		return nil, err
+	}
+	flat, err := parseLexicon(l.source(true))
+	if err != nil {
+		return nil, err
+	}
+	if l.registry != nil {
+		if err := flat.link(func(id string) *ABITLexicon { return l.registry.lexicons[id] }); err != nil {
+			return nil, err
+		}
+	}
+	return flat, nil
+}
+
+// source returns the lexicon in its JSON format, as decoded by decodeJSON.
+// With flat, trees with parents are written with all their keys, and
+// references qualified with the id of their lexicon.
+func (l *ABITLexicon) source(flat bool) map[string]interface{} {
+	lexicon := l.bodySource(flat)
+	lexicon[lexVersionKey] = json.Number(strconv.Itoa(lexVersion))
+	return lexicon
+}
+
+// bodySource returns the source of the lexicon without lexVersionKey.
+func (l *ABITLexicon) bodySource(flat bool) map[string]interface{} {
+	if l.root == nil {
+		return map[string]interface{}{}
+	}
+	root := l.root
+	if l.id == "" && len(l.defs) == 1 && root.ref == nil && root.union == nil && root.additional == nil && (flat || root.extends == nil) {
+		fields := root.treeSource(flat)
+		if _, ok := fields[lexVersionKey]; !ok && !isLexEnvelope(fields) {
+			return fields
+		}
+	}
+	defs := make(map[string]interface{}, len(l.defs))
+	for name, def := range l.defs {
+		defs[name] = def.source(flat)
+	}
+	lexicon := map[string]interface{}{
+		"defs": defs,
+	}
+	if l.id != "" {
+		lexicon["id"] = l.id
+	}
+	return lexicon
+}
+
+// source returns the schema of the node in the JSON format of lexicons, as
+// decoded by decodeJSON. It is the shortest form that parses back into the
+// same node.
+func (n *lexNode) source(flat bool) interface{} {
+	if n.ref != nil {
+		switch {
+		case flat && n.ref.id == "" && n.ref.scope != "":
+			return map[string]interface{}{"$ref": n.ref.scope + "#/defs/" + n.ref.name}
+		case !flat && n.ref.id != "" && n.ref.id == n.ref.scope:
+			// References to the lexicon itself do not depend on its id.
+			return map[string]interface{}{"$ref": "#/defs/" + n.ref.name}
+		}
+		return map[string]interface{}{"$ref": n.ref.String()}
+	}
+	if n.union != nil {
+		return n.union.source(flat)
+	}
+	if n.kinds == kindBit(KindArray) && n.tuple != nil {
+		tuple := make([]interface{}, len(n.tuple))
+		for i := range n.tuple {
+			tuple[i] = n.tuple[i].source(flat)
+		}
+		return tuple
+	}
+	if n.any {
+		return "any"
+	}
+	if n.kinds == kindBit(KindTree) && n.additional == nil && (flat || n.extends == nil) {
+		if fields := n.treeSource(flat); !isLexDescription(fields) && !isLexRef(fields) && !isLexUnion(fields) && !isLexAllOf(fields) {
+			return fields
+		}
+	}
+	if n.extends != nil && !flat && n.kinds == kindBit(KindTree) && n.additional == nil && len(n.overlay) == 0 {
+		return map[string]interface{}{"allOf": n.parentsSource()}
+	}
+
+	d := map[string]interface{}{
+		"type": kindsString(n.kinds),
+	}
+	if n.items != nil {
+		d["items"] = n.items.source(flat)
+	}
+	if n.accepts(KindTree) && len(n.ownFields(flat)) > 0 {
+		d["keys"] = n.treeSource(flat)
+	}
+	if n.extends != nil && !flat {
+		d["extends"] = n.parentsSource()
+	}
+	if n.additional != nil {
+		if n.additional.any {
+			d["additionalKeys"] = true
+		} else {
+			d["additionalKeys"] = n.additional.source(flat)
+		}
+	}
+	if n.enum != nil {
+		values := make([]interface{}, len(n.enum))
+		for i, value := range n.enum {
+			if integer, ok := value.(int64); ok {
+				value = json.Number(strconv.FormatInt(integer, 10))
+			}
+			values[i] = value
+		}
+		if n.isConst {
+			d["const"] = values[0]
+		} else {
+			d["enum"] = values
+		}
+	}
+	if n.keyPattern != nil {
+		d["keyPattern"] = n.keyPattern.String()
+	}
+	if n.pattern != nil {
+		d["pattern"] = n.pattern.String()
+	}
+	if n.format != "" {
+		d["format"] = n.format
+	}
+	for keyword, value := range map[string]*int64{
+		"minItems":     n.minItems,
+		"maxItems":     n.maxItems,
+		"minimum":      n.minimum,
+		"maximum":      n.maximum,
+		"minLength":    n.minLength,
+		"maxLength":    n.maxLength,
+		"minSize":      n.minSize,
+		"maxSize":      n.maxSize,
+		"minKeyLength": n.minKeyLength,
+		"maxKeyLength": n.maxKeyLength,
+	} {
+		if value != nil {
+			d[keyword] = json.Number(strconv.FormatInt(*value, 10))
+		}
+	}
+	if len(d) == 1 && !n.accepts(KindArray) && !n.accepts(KindTree) {
+		return d["type"]
+	}
+	return d
+}
+
+// parentsSource returns the parents of a tree with extends in the JSON format
+// of lexicons.
+func (n *lexNode) parentsSource() []interface{} {
+	parents := make([]interface{}, len(n.extends))
+	for i := range n.extends {
+		parents[i] = n.extends[i].source(false)
+	}
+	return parents
+}
+
+func (u *lexUnion) source(flat bool) map[string]interface{} {
+	keyword := "anyOf"
+	if u.exclusive {
+		keyword = "oneOf"
+	}
+	if u.discriminator == "" {
+		branches := make([]interface{}, len(u.branches))
+		for i := range u.branches {
+			branches[i] = u.branches[i].source(flat)
+		}
+		return map[string]interface{}{keyword: branches}
+	}
+	branches := make(map[string]interface{}, len(u.branches))
+	for i := range u.branches {
+		branches[u.names[i]] = u.branches[i].source(flat)
+	}
+	source := map[string]interface{}{
+		keyword:         branches,
+		"discriminator": u.discriminator,
+	}
+	if u.fallback != nil {
+		source["fallback"] = u.fallback.source(flat)
+	}
+	return source
+}
+
+// treeSource returns the keys of a tree node in the JSON format of lexicons.
+// Without flat only the keys of the tree itself are included.
+func (n *lexNode) treeSource(flat bool) map[string]interface{} {
+	own := n.ownFields(flat)
+	fields := make(map[string]interface{}, len(own))
+	for key, field := range own {
+		if field.optional {
+			key += "?"
+		}
+		fields[key] = field.node.source(flat)
+	}
+	return fields
+}
+
+// lexSourceToABIT converts a lexicon in the format of decodeJSON to a value
+// accepted by Put.
+func lexSourceToABIT(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return Null{}, nil
+	case bool, string:
+		return v, nil
+	case json.Number:
+		return jsonInteger(v, path)
+	case []interface{}:
+		arr := NewABITArray()
+		for i := range v {
+			o, err := lexSourceToABIT(v[i], appendPath(path, indexSegment(i)))
+			if err != nil {
+				return nil, err
+			}
+			arr.Add(o)
+		}
+		return *arr, nil
+	case map[string]interface{}:
+		tree, _ := NewABITObject(&[]byte{})
+		for key, value := range v {
+			if err := checkKey(key, path); err != nil {
+				return nil, err
+			}
+			o, err := lexSourceToABIT(value, appendPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			tree.Put(key, o)
+		}
+		return *tree, nil
+	}
+	return nil, fmt.Errorf("unsupported lexicon value at %s", pathOrRoot(path))
+}
+
+// abitToLexSource converts a lexicon encoded in ABIT to the format of
+// decodeJSON.
+func abitToLexSource(obj *ABITObject, path string) (interface{}, error) {
+	switch obj.dataType {
+	case 0b0000: // Null
+		return nil, nil
+	case 0b0001: // Boolean
+		return obj.boolean, nil
+	case 0b0010: // Integer
+		return json.Number(strconv.FormatInt(obj.integer, 10)), nil
+	case 0b0100: // String
+		return *obj.text, nil
+	case 0b0101: // Array
+		values := make([]interface{}, len(obj.array.array))
+		for i, item := range obj.array.array {
+			v, err := abitToLexSource(item, appendPath(path, indexSegment(i)))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case 0b0110: // Tree
+		m := make(map[string]interface{}, len(obj.tree))
+		for key, child := range obj.tree {
+			v, err := abitToLexSource(child, appendPath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("%s at %s is not allowed in lexicons", Kind(obj.dataType), pathOrRoot(path))
+}
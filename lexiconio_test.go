@@ -0,0 +1,99 @@
+package abit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+const testLexicon = `{
+	"$lexicon": 1,
+	"name": "string",
+	"nickname?": "string|null",
+	"pair": ["integer", {"type": "blob", "maxSize": 4}],
+	"tags": {"type": "array|null", "items": {"type": "string", "pattern": "^#", "format": "uri"}, "maxItems": 3},
+	"meta": {"type": "tree", "keys": {"type": "string", "keys": {}}},
+	"age?": {"type": "integer|string", "minimum": -1, "minLength": 2}
+}`
+
+func TestLexiconByteArray(t *testing.T) {
+	lex := InitLexicon(testLexicon)
+	doc := lex.ToByteArray()
+
+	parsed, err := ParseLexicon(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.ToByteArray(), doc) {
+		t.Fatal("lexicon changed after encoding and parsing")
+	}
+
+	tree, _ := NewABITObject(&doc)
+	if *tree.GetString("nickname?") != "null|string" {
+		t.Fatal("optional key not encoded as in JSON")
+	}
+	if tree.GetTree("meta").GetTree("keys").KindOf("type") != KindString {
+		t.Fatal("tree with keyword keys not encoded as description")
+	}
+	if tree.GetArray("pair").GetTree(1).GetInteger("maxSize") != 4 {
+		t.Fatal("constraint not encoded as integer")
+	}
+
+	for _, invalid := range [][]byte{
+		{0xff},
+		func() []byte {
+			tree, _ := NewABITObject(&[]byte{})
+			tree.Put("a", []byte{1})
+			return tree.ToByteArray()
+		}(),
+		func() []byte {
+			tree, _ := NewABITObject(&[]byte{})
+			tree.Put("a", "tree")
+			return tree.ToByteArray()
+		}(),
+	} {
+		if _, err := ParseLexicon(invalid); err == nil {
+			t.Fatalf("invalid lexicon % x parsed", invalid)
+		}
+	}
+}
+
+func TestLoadLexicon(t *testing.T) {
+	lex := InitLexicon(testLexicon)
+	doc := lex.ToByteArray()
+	fsys := fstest.MapFS{
+		"lex/a.json":  {Data: []byte(testLexicon)},
+		"lex/a.abit":  {Data: doc},
+		"lex/b.json":  {Data: []byte(`{"a":"word"}`)},
+		"lex/c.abit":  {Data: []byte(testLexicon)},
+		"lex/d.json":  {Data: []byte(`["string"]`)},
+		"lex/e.jsonl": {Data: []byte(`{}`)},
+	}
+	for _, name := range []string{"lex/a.json", "lex/a.abit"} {
+		lex, err := LoadLexiconFS(fsys, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(lex.ToByteArray(), doc) {
+			t.Fatalf("%s loaded a different lexicon", name)
+		}
+	}
+	for _, name := range []string{"lex/b.json", "lex/c.abit", "lex/d.json", "lex/e.jsonl", "lex/missing.json"} {
+		if _, err := LoadLexiconFS(fsys, name); err == nil {
+			t.Fatalf("%s loaded when it shouldn't", name)
+		}
+	}
+
+	name := filepath.Join(t.TempDir(), "a.json")
+	if err := os.WriteFile(name, []byte(testLexicon), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadLexiconFile(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseLexiconJSON([]byte(`{"a":`)); err == nil {
+		t.Fatal("truncated JSON parsed")
+	}
+}
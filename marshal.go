@@ -0,0 +1,576 @@
+package abit
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+var (
+	nullType       = reflect.TypeOf(Null{})
+	abitObjectType = reflect.TypeOf(ABITObject{})
+	abitArrayType  = reflect.TypeOf(ABITArray{})
+)
+
+// Marshal returns the binary ABIT document of v.
+//
+// v must be a struct, a map with string keys or a pointer to one of them,
+// as the root of a document is a tree. Values are converted as follows:
+//   - bool is a boolean
+//   - all signed and unsigned integer types are integers, unsigned values above math.MaxInt64 are an error
+//   - string is a string
+//   - []byte and [N]byte are blobs, as are slices and arrays of other byte types
+//   - other slices and arrays are arrays
+//   - structs and maps with string keys are trees
+//   - a nil pointer or interface is null, otherwise the value it points to is used
+//   - abit.Null, ABITArray and ABITObject are used as they are
+//
+// Struct fields are stored under their name unless the field has an abit
+// tag. Unexported fields and fields tagged "-" are ignored. The fields of
+// embedded structs without a tag name are stored in the tree of the struct
+// embedding them, following the rules of encoding/json when names collide.
+// Values that contain themselves through pointers, maps or slices are an
+// error.
+//
+// # Example
+//
+//	type Profile struct {
+//		Name     string  `abit:"name"`
+//		Nickname *string `abit:"nickname,omitempty"`
+//		Age      uint8   `abit:"age"`
+//		Tags     []string
+//		Secret   string `abit:"-"`
+//	}
+//
+//	doc, err := abit.Marshal(Profile{Name: "päror", Age: 46})
+func Marshal(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("cannot marshal nil as an abit document")
+	}
+	for rv.Kind() == reflect.Pointer || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil, fmt.Errorf("cannot marshal nil as an abit document")
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct && rv.Kind() != reflect.Map && rv.Type() != abitObjectType {
+		return nil, fmt.Errorf("cannot marshal %s as an abit document, it must be a struct or a map", rv.Type())
+	}
+
+	value, err := marshalValue(rv, "", map[marshalRef]bool{})
+	if err != nil {
+		return nil, err
+	}
+	tree := value.(ABITObject)
+	return tree.ToByteArray(), nil
+}
+
+// Unmarshal parses the binary ABIT document in data and stores the result in
+// the value pointed to by v.
+//
+// The conversions are the inverse of Marshal. Keys in the document without a
+// matching struct field are ignored. Null can only be stored in pointers,
+// interfaces and abit.Null. Integers that do not fit the Go type are an
+// error. Values stored in an empty interface become nil, bool, int64,
+// []byte, string, []interface{} or map[string]interface{}.
+//
+// # Example
+//
+//	var p Profile
+//	if err := abit.Unmarshal(doc, &p); err != nil {
+//		// Handle invalid document here
+//	}
+func Unmarshal(data []byte, v interface{}) error {
+	return UnmarshalWithOptions(data, v, DecodeOptions{})
+}
+
+// UnmarshalWithOptions is like Unmarshal, decoding data with the given
+// options.
+func UnmarshalWithOptions(data []byte, v interface{}, opts DecodeOptions) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("cannot unmarshal into %s, it must be a non-nil pointer", reflect.TypeOf(v))
+	}
+
+	tree, err := NewABITObjectWithOptions(&data, opts)
+	if err != nil {
+		return err
+	}
+	return unmarshalValue(tree, rv.Elem(), "")
+}
+
+type structField struct {
+	name string
+	// index is the path of the field through embedded structs, as used by
+	// reflect.Value.FieldByIndex.
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+// structFields returns the fields of a struct type that are stored in trees.
+// The fields of embedded structs without a tag name are promoted like in
+// encoding/json: a field hides fields of the same name nested deeper, and of
+// fields at the same depth the only tagged one wins, otherwise the name is
+// left out. Fields of the struct itself are never left out, so giving two of
+// them the same name is an error when marshalling.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	collectFields(t, nil, map[reflect.Type]bool{}, &fields)
+
+	byName := map[string][]structField{}
+	for _, f := range fields {
+		byName[f.name] = append(byName[f.name], f)
+	}
+	kept := make([]structField, 0, len(fields))
+	for _, f := range fields {
+		if dominates(f, byName[f.name]) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// dominates reports whether f is stored rather than the other fields with
+// its name in same.
+func dominates(f structField, same []structField) bool {
+	depth := len(f.index)
+	rivals, tagged := 0, 0
+	for _, other := range same {
+		if len(other.index) < depth {
+			return false
+		}
+		if len(other.index) == depth {
+			rivals++
+			if other.tagged {
+				tagged++
+			}
+		}
+	}
+	if depth == 1 || rivals == 1 {
+		return true
+	}
+	return f.tagged && tagged == 1
+}
+
+// collectFields appends the fields of t to fields, with the fields of
+// embedded structs in place of the structs. visiting holds the embedded
+// types t is nested in, which are not entered again.
+func collectFields(t reflect.Type, index []int, visiting map[reflect.Type]bool, fields *[]structField) {
+	visiting[t] = true
+	defer delete(visiting, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("abit")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int{}, index...), i)
+		if f.Anonymous && name == "" {
+			embedded := f.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				// Pointers to unexported structs can not be allocated when
+				// unmarshalling, like in encoding/json.
+				if (f.IsExported() || f.Type.Kind() != reflect.Pointer) && !visiting[embedded] {
+					collectFields(embedded, fieldIndex, visiting, fields)
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		tagged := name != ""
+		if name == "" {
+			name = f.Name
+		}
+		*fields = append(*fields, structField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: opts == "omitempty",
+			tagged:    tagged,
+		})
+	}
+}
+
+// fieldByIndex returns the field of the struct rv at index, or false if it
+// is in an embedded struct behind a nil pointer.
+func fieldByIndex(rv reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				return reflect.Value{}, false
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// settableFieldByIndex is like fieldByIndex, allocating the nil pointers to
+// embedded structs on the way.
+func settableFieldByIndex(rv reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Pointer {
+			if rv.IsNil() {
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Struct:
+		return false
+	}
+	return v.IsZero()
+}
+
+func checkKey(key string, path string) error {
+	if len(key) > 256 {
+		return fmt.Errorf("key too long at %s", appendPath(path, key))
+	} else if len(key) < 1 {
+		return fmt.Errorf("key too short at %s", appendPath(path, "\"\""))
+	}
+	return nil
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "document root"
+	}
+	return path
+}
+
+// marshalRef identifies a pointer, map or slice being marshalled, so values
+// containing themselves are detected.
+type marshalRef struct {
+	ptr uintptr
+	len int
+	typ reflect.Type
+}
+
+// enter marks the pointer, map or slice rv as being marshalled and reports
+// false if it already is.
+func enter(visiting map[marshalRef]bool, rv reflect.Value) (marshalRef, bool) {
+	ref := marshalRef{ptr: rv.Pointer(), typ: rv.Type()}
+	if rv.Kind() == reflect.Slice {
+		ref.len = rv.Len()
+	}
+	if visiting[ref] {
+		return ref, false
+	}
+	visiting[ref] = true
+	return ref, true
+}
+
+// marshalBlob copies a slice or array of a byte type into a blob.
+func marshalBlob(rv reflect.Value) []byte {
+	blob := make([]byte, rv.Len())
+	for i := range blob {
+		blob[i] = byte(rv.Index(i).Uint())
+	}
+	return blob
+}
+
+// marshalValue converts rv to a value accepted by ABITObject.Put. visiting
+// holds the pointers, maps and slices rv is nested in.
+func marshalValue(rv reflect.Value, path string, visiting map[marshalRef]bool) (interface{}, error) {
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if !rv.IsNil() && (rv.Kind() != reflect.Slice || rv.Len() > 0) {
+			ref, ok := enter(visiting, rv)
+			if !ok {
+				return nil, fmt.Errorf("cannot marshal %s at %s, it contains itself", rv.Type(), pathOrRoot(path))
+			}
+			defer delete(visiting, ref)
+		}
+	}
+	switch rv.Type() {
+	case nullType:
+		return Null{}, nil
+	case abitObjectType:
+		tree := rv.Interface().(ABITObject)
+		if tree.dataType != 0b0110 {
+			return nil, fmt.Errorf("ABITObject is invalid type at %s", pathOrRoot(path))
+		}
+		return tree, nil
+	case abitArrayType:
+		return rv.Interface().(ABITArray), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return nil, fmt.Errorf("integer %d overflows int64 at %s", u, pathOrRoot(path))
+		}
+		return int64(u), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return marshalBlob(rv), nil
+		}
+		arr := NewABITArray()
+		for i := 0; i < rv.Len(); i++ {
+			value, err := marshalValue(rv.Index(i), appendPath(path, indexSegment(i)), visiting)
+			if err != nil {
+				return nil, err
+			}
+			arr.Add(value)
+		}
+		return *arr, nil
+	case reflect.Struct:
+		tree, _ := NewABITObject(&[]byte{})
+		for _, f := range structFields(rv.Type()) {
+			field, ok := fieldByIndex(rv, f.index)
+			if !ok || f.omitEmpty && isEmptyValue(field) {
+				continue
+			}
+			if err := checkKey(f.name, path); err != nil {
+				return nil, err
+			}
+			if _, ok := tree.tree[f.name]; ok {
+				return nil, fmt.Errorf("duplicate key %q at %s", f.name, pathOrRoot(path))
+			}
+			value, err := marshalValue(field, appendPath(path, f.name), visiting)
+			if err != nil {
+				return nil, err
+			}
+			tree.Put(f.name, value)
+		}
+		return *tree, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("cannot marshal map with %s keys at %s", rv.Type().Key(), pathOrRoot(path))
+		}
+		tree, _ := NewABITObject(&[]byte{})
+		iter := rv.MapRange()
+		for iter.Next() {
+			key := iter.Key().String()
+			if err := checkKey(key, path); err != nil {
+				return nil, err
+			}
+			value, err := marshalValue(iter.Value(), appendPath(path, key), visiting)
+			if err != nil {
+				return nil, err
+			}
+			tree.Put(key, value)
+		}
+		return *tree, nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return Null{}, nil
+		}
+		return marshalValue(rv.Elem(), path, visiting)
+	}
+	return nil, fmt.Errorf("cannot marshal %s at %s", rv.Type(), pathOrRoot(path))
+}
+
+func unmarshalTypeError(obj *ABITObject, rv reflect.Value, path string) error {
+	return fmt.Errorf("cannot unmarshal %s into Go value of type %s at %s", Kind(obj.dataType), rv.Type(), pathOrRoot(path))
+}
+
+// unmarshalBlob copies blob into a slice or array of a byte type of the same
+// length.
+func unmarshalBlob(blob []byte, rv reflect.Value) {
+	for i, b := range blob {
+		rv.Index(i).SetUint(uint64(b))
+	}
+}
+
+// unmarshalValue stores obj in rv.
+func unmarshalValue(obj *ABITObject, rv reflect.Value, path string) error {
+	switch rv.Type() {
+	case nullType:
+		if obj.dataType != 0b0000 {
+			return unmarshalTypeError(obj, rv, path)
+		}
+		return nil
+	case abitObjectType:
+		if obj.dataType != 0b0110 {
+			return unmarshalTypeError(obj, rv, path)
+		}
+		rv.Set(reflect.ValueOf(*obj))
+		return nil
+	case abitArrayType:
+		if obj.dataType != 0b0101 {
+			return unmarshalTypeError(obj, rv, path)
+		}
+		rv.Set(reflect.ValueOf(*obj.array))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if obj.dataType == 0b0000 {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshalValue(obj, rv.Elem(), path)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return unmarshalTypeError(obj, rv, path)
+		}
+		if obj.dataType == 0b0000 {
+			rv.Set(reflect.Zero(rv.Type()))
+			return nil
+		}
+		value := interfaceValue(obj)
+		rv.Set(reflect.ValueOf(value))
+		return nil
+	}
+
+	switch obj.dataType {
+	case 0b0001:
+		if rv.Kind() != reflect.Bool {
+			return unmarshalTypeError(obj, rv, path)
+		}
+		rv.SetBool(obj.boolean)
+		return nil
+	case 0b0010:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if rv.OverflowInt(obj.integer) {
+				return fmt.Errorf("integer %d overflows %s at %s", obj.integer, rv.Type(), pathOrRoot(path))
+			}
+			rv.SetInt(obj.integer)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj.integer < 0 || rv.OverflowUint(uint64(obj.integer)) {
+				return fmt.Errorf("integer %d overflows %s at %s", obj.integer, rv.Type(), pathOrRoot(path))
+			}
+			rv.SetUint(uint64(obj.integer))
+			return nil
+		}
+	case 0b0011:
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8 {
+			slice := reflect.MakeSlice(rv.Type(), len(*obj.blob), len(*obj.blob))
+			unmarshalBlob(*obj.blob, slice)
+			rv.Set(slice)
+			return nil
+		}
+		if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+			if rv.Len() != len(*obj.blob) {
+				return fmt.Errorf("blob of %d bytes does not fit %s at %s", len(*obj.blob), rv.Type(), pathOrRoot(path))
+			}
+			unmarshalBlob(*obj.blob, rv)
+			return nil
+		}
+	case 0b0100:
+		if rv.Kind() == reflect.String {
+			rv.SetString(*obj.text)
+			return nil
+		}
+	case 0b0101:
+		switch rv.Kind() {
+		case reflect.Slice:
+			if rv.Type().Elem().Kind() == reflect.Uint8 {
+				break
+			}
+			slice := reflect.MakeSlice(rv.Type(), len(obj.array.array), len(obj.array.array))
+			for i, o := range obj.array.array {
+				if err := unmarshalValue(o, slice.Index(i), appendPath(path, indexSegment(i))); err != nil {
+					return err
+				}
+			}
+			rv.Set(slice)
+			return nil
+		case reflect.Array:
+			if rv.Type().Elem().Kind() == reflect.Uint8 {
+				break
+			}
+			if rv.Len() != len(obj.array.array) {
+				return fmt.Errorf("array of %d values does not fit %s at %s", len(obj.array.array), rv.Type(), pathOrRoot(path))
+			}
+			for i, o := range obj.array.array {
+				if err := unmarshalValue(o, rv.Index(i), appendPath(path, indexSegment(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case 0b0110:
+		switch rv.Kind() {
+		case reflect.Struct:
+			for _, f := range structFields(rv.Type()) {
+				o, ok := obj.tree[f.name]
+				if !ok {
+					continue
+				}
+				if err := unmarshalValue(o, settableFieldByIndex(rv, f.index), appendPath(path, f.name)); err != nil {
+					return err
+				}
+			}
+			return nil
+		case reflect.Map:
+			if rv.Type().Key().Kind() != reflect.String {
+				break
+			}
+			if rv.IsNil() {
+				rv.Set(reflect.MakeMapWithSize(rv.Type(), len(obj.tree)))
+			}
+			for key, o := range obj.tree {
+				elem := reflect.New(rv.Type().Elem()).Elem()
+				if err := unmarshalValue(o, elem, appendPath(path, key)); err != nil {
+					return err
+				}
+				rv.SetMapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()), elem)
+			}
+			return nil
+		}
+	}
+	return unmarshalTypeError(obj, rv, path)
+}
+
+// interfaceValue converts obj to a plain Go value.
+func interfaceValue(obj *ABITObject) interface{} {
+	switch obj.dataType {
+	case 0b0001:
+		return obj.boolean
+	case 0b0010:
+		return obj.integer
+	case 0b0011:
+		blob := make([]byte, len(*obj.blob))
+		copy(blob, *obj.blob)
+		return blob
+	case 0b0100:
+		return *obj.text
+	case 0b0101:
+		arr := make([]interface{}, len(obj.array.array))
+		for i, o := range obj.array.array {
+			arr[i] = interfaceValue(o)
+		}
+		return arr
+	case 0b0110:
+		tree := make(map[string]interface{}, len(obj.tree))
+		for key, o := range obj.tree {
+			tree[key] = interfaceValue(o)
+		}
+		return tree
+	}
+	return nil
+}
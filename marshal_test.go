@@ -0,0 +1,256 @@
+package abit
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type marshalAddress struct {
+	Street string `abit:"street"`
+	Number uint16 `abit:"number"`
+}
+
+type marshalProfile struct {
+	Name     string            `abit:"name"`
+	Nickname *string           `abit:"nickname,omitempty"`
+	Partner  *string           `abit:"partner"`
+	Age      uint8             `abit:"age"`
+	Balance  int64             `abit:"balance"`
+	Small    int8              `abit:"small"`
+	Verified bool              `abit:"verified"`
+	Avatar   []byte            `abit:"avatar"`
+	Hash     [4]byte           `abit:"hash"`
+	Tags     []string          `abit:"tags"`
+	Home     marshalAddress    `abit:"home"`
+	Old      []*marshalAddress `abit:"old"`
+	Labels   map[string]int    `abit:"labels"`
+	Extra    interface{}       `abit:"extra"`
+	Secret   string            `abit:"-"`
+	Untagged int
+	private  int
+}
+
+func TestMarshalEmbedded(t *testing.T) {
+	type Base struct {
+		ID   string `abit:"id"`
+		Note string
+	}
+	type Named struct {
+		Name string
+		Note string
+	}
+	type embedding struct {
+		Base
+		*Named
+		Home marshalAddress `abit:"home"`
+		Size int
+	}
+
+	in := embedding{
+		Base:  Base{ID: "a", Note: "base"},
+		Named: &Named{Name: "cat", Note: "named"},
+		Home:  marshalAddress{Street: "Storgatan"},
+		Size:  2,
+	}
+	doc := mustMarshal(t, in)
+	tree, err := NewABITObject(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Note is in both embedded structs at the same depth, so it is left out.
+	if keys := sortedKeys(tree.tree); !reflect.DeepEqual(keys, []string{"id", "Name", "Size", "home"}) {
+		t.Fatalf("embedded fields stored as keys %v", keys)
+	}
+	var out embedding
+	if err := Unmarshal(doc, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Base.Note, in.Named.Note = "", ""
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("unmarshalled %+v, want %+v", out, in)
+	}
+
+	doc = mustMarshal(t, embedding{Base: Base{ID: "b"}})
+	if tree, _ := NewABITObject(&doc); tree.Has("Name") {
+		t.Fatal("fields of nil embedded pointer stored")
+	}
+
+	type outer struct {
+		Base
+		Note  string
+		Named Named `abit:"named"`
+	}
+	doc = mustMarshal(t, outer{Base: Base{ID: "c", Note: "hidden"}, Note: "shown"})
+	tree, _ = NewABITObject(&doc)
+	if note := tree.GetString("Note"); note == nil || *note != "shown" || !tree.Has("id") || !tree.Has("named") {
+		t.Fatalf("outer fields must hide embedded ones and tagged structs stay nested, got keys %v", sortedKeys(tree.tree))
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	nickname := "potatis"
+	in := marshalProfile{
+		Name:     "päror",
+		Nickname: &nickname,
+		Age:      46,
+		Balance:  -69696969420,
+		Small:    -128,
+		Verified: true,
+		Avatar:   []byte{0, 4, 1, 0},
+		Hash:     [4]byte{1, 2, 3, 4},
+		Tags:     []string{"landet", "riktnummer"},
+		Home:     marshalAddress{Street: "Storgatan", Number: 410},
+		Old:      []*marshalAddress{{Street: "Lillgatan", Number: 1}, nil},
+		Labels:   map[string]int{"a": 1, "b": 2},
+		Extra:    "anything",
+		Secret:   "hidden",
+		Untagged: 7,
+		private:  8,
+	}
+
+	doc, err := Marshal(&in)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	tree, err := NewABITObject(&doc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if *tree.GetString("name") != "päror" || tree.GetInteger("age") != 46 {
+		t.Fatal("incorrect value")
+	}
+	tree.GetNull("partner")
+	if tree.GetTree("home").GetInteger("number") != 410 {
+		t.Fatal("incorrect value")
+	}
+	for _, key := range tree.Keys() {
+		if key == "Secret" || key == "private" {
+			t.Fatalf("%s should not be marshalled", key)
+		}
+	}
+
+	var out marshalProfile
+	if err := Unmarshal(doc, &out); err != nil {
+		t.Fatal(err.Error())
+	}
+	in.Secret = ""
+	in.private = 0
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unmarshalled value differs:\n%+v\n%+v", in, out)
+	}
+
+	in.Nickname = nil
+	doc, _ = Marshal(in)
+	tree, _ = NewABITObject(&doc)
+	for _, key := range tree.Keys() {
+		if key == "nickname" {
+			t.Fatal("empty nickname should be omitted")
+		}
+	}
+
+	doc2, err := Marshal(map[string]interface{}{"tree": out.Home})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var m map[string]marshalAddress
+	if err := Unmarshal(doc2, &m); err != nil {
+		t.Fatal(err.Error())
+	}
+	if m["tree"] != out.Home {
+		t.Fatal("incorrect value")
+	}
+	if !bytes.Equal(doc2, mustMarshal(t, map[string]marshalAddress{"tree": out.Home})) {
+		t.Fatal("abit not equal")
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	doc, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return doc
+}
+
+func TestMarshalErrors(t *testing.T) {
+	if _, err := Marshal(int64(5)); err == nil {
+		t.Fatal("root must be a tree")
+	}
+	if _, err := Marshal(map[string]uint64{"big": math.MaxUint64}); err == nil {
+		t.Fatal("uint64 above math.MaxInt64 should overflow")
+	}
+	if _, err := Marshal(map[string]int{strings.Repeat(" ", 257): 1}); err == nil {
+		t.Fatal("key too long should be an error")
+	}
+	if _, err := Marshal(map[string]float64{"pi": 3.14}); err == nil {
+		t.Fatal("floats are not supported")
+	}
+	if _, err := Marshal(nil); err == nil {
+		t.Fatal("nil should be an error")
+	}
+	type node struct {
+		Next *node
+	}
+	loop := &node{}
+	loop.Next = loop
+	if _, err := Marshal(loop); err == nil {
+		t.Fatal("pointer cycle should be an error")
+	}
+	cycle := map[string]interface{}{}
+	cycle["self"] = cycle
+	if _, err := Marshal(cycle); err == nil {
+		t.Fatal("map cycle should be an error")
+	}
+	shared := &marshalAddress{Street: "Storgatan"}
+	if _, err := Marshal(struct{ A, B *marshalAddress }{shared, shared}); err != nil {
+		t.Fatalf("shared pointer is not a cycle: %v", err)
+	}
+
+	type namedByte uint8
+	type namedBlobs struct {
+		Slice []namedByte
+		Array [2]namedByte
+	}
+	doc := mustMarshal(t, namedBlobs{Slice: []namedByte{1, 2, 3}, Array: [2]namedByte{4, 5}})
+	var blobs namedBlobs
+	if err := Unmarshal(doc, &blobs); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(blobs, namedBlobs{Slice: []namedByte{1, 2, 3}, Array: [2]namedByte{4, 5}}) {
+		t.Fatalf("named byte types changed to %v", blobs)
+	}
+
+	doc = mustMarshal(t, map[string]int64{"small": -129, "big": math.MaxInt64})
+	var small struct {
+		Small int8 `abit:"small"`
+	}
+	if err := Unmarshal(doc, &small); err == nil {
+		t.Fatal("-129 should overflow int8")
+	}
+	var big struct {
+		Big uint32 `abit:"big"`
+	}
+	if err := Unmarshal(doc, &big); err == nil {
+		t.Fatal("math.MaxInt64 should overflow uint32")
+	}
+	var negative struct {
+		Small uint64 `abit:"small"`
+	}
+	if err := Unmarshal(doc, &negative); err == nil {
+		t.Fatal("negative integer should not fit uint64")
+	}
+	var wrong struct {
+		Small string `abit:"small"`
+	}
+	if err := Unmarshal(doc, &wrong); err == nil {
+		t.Fatal("integer should not unmarshal into string")
+	}
+	if err := Unmarshal(doc, small); err == nil {
+		t.Fatal("unmarshal requires a pointer")
+	}
+}
@@ -0,0 +1,219 @@
+package abit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+var (
+	// ErrNonCanonical means an integer or length prefix does not use the
+	// minimum number of bytes. It is only reported in strict mode.
+	ErrNonCanonical = errors.New("integer not in minimal form")
+	// ErrInvalidUTF8 means a key or string is not valid UTF-8. It is only
+	// reported in strict mode.
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+	// ErrLimitExceeded means the document exceeds one of the limits in
+	// DecodeOptions. The error is a *LimitError naming the limit.
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
+
+// LimitError is the cause of a DecodeError when a document exceeds one of
+// the limits in DecodeOptions.
+//
+// # Example
+//
+//	var limitErr *abit.LimitError
+//	if errors.As(err, &limitErr) {
+//		fmt.Println(limitErr.Limit, limitErr.Max)
+//	}
+type LimitError struct {
+	// Limit is the name of the field in DecodeOptions, such as "MaxDepth".
+	Limit string
+	// Max is the value of the limit.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded", e.Limit, e.Max)
+}
+
+// Is makes errors.Is(err, ErrLimitExceeded) true for every LimitError.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// DecodeOptions controls how documents are decoded.
+//
+// The zero value decodes documents the same way as NewABITObject.
+type DecodeOptions struct {
+	// Strict only accepts documents in canonical form, so that every tree
+	// has exactly one encoding. This matters when documents are hashed or
+	// signed. In strict mode:
+	//   - integers and length prefixes must use the minimum number of bytes
+	//   - keys and strings must be valid UTF-8
+	//
+	// Keys out of canonical order, duplicate keys, unused bits set in type
+	// bytes and null or boolean bytes other than the ones in the spec are
+	// rejected in every mode. A document accepted in strict mode encodes
+	// to exactly the same bytes with ToByteArray.
+	Strict bool
+
+	// Limits protect against hostile documents. A limit of 0 means no
+	// limit. Documents exceeding a limit return a DecodeError caused by a
+	// *LimitError.
+
+	// MaxDepth is the maximum number of trees and arrays nested inside
+	// each other, not counting the document itself.
+	MaxDepth int
+	// MaxElements is the maximum number of values in the whole document,
+	// including trees and arrays.
+	MaxElements int64
+	// MaxBlobSize is the maximum number of bytes in a blob or string.
+	MaxBlobSize int64
+	// MaxKeys is the maximum number of keys in a single tree.
+	MaxKeys int
+	// MaxDocumentSize is the maximum number of bytes in the document.
+	MaxDocumentSize int64
+}
+
+// decodeState holds the options and running totals of a single decode.
+type decodeState struct {
+	opts     DecodeOptions
+	depth    int
+	elements int64
+}
+
+// NewABITObjectWithOptions creates an ABIT object from a binary ABIT document
+// like NewABITObject, using the given options.
+//
+// # Example
+//
+//	// Only accept canonical documents
+//	tree, err := abit.NewABITObjectWithOptions(&doc, abit.DecodeOptions{Strict: true})
+func NewABITObjectWithOptions(document *[]byte, opts DecodeOptions) (*ABITObject, error) {
+	s := &decodeState{opts: opts}
+	if err := s.checkDocumentSize(int64(len(*document))); err != nil {
+		return nil, err
+	}
+	if len(*document) > 0 {
+		tree, err := s.decodeTree(&sliceSource{doc: *document}, -1)
+		if err != nil {
+			return nil, err
+		}
+		return &([]ABITObject{tree}[0]), nil
+	} else {
+		tree := ABITObject{
+			dataType: 0b0110,
+			tree:     map[string]*ABITObject{},
+		}
+		return &([]ABITObject{tree}[0]), nil
+	}
+}
+
+// NewDecoderWithOptions returns a Decoder that reads from r like NewDecoder,
+// using the given options.
+//
+// The decoded document is held in memory, so set MaxDocumentSize to bound
+// the memory used for it.
+func NewDecoderWithOptions(r io.Reader, opts DecodeOptions) *Decoder {
+	d := NewDecoder(r)
+	d.state.opts = opts
+	return d
+}
+
+// NewTokenReaderWithOptions returns a TokenReader reading the document in buf
+// like NewTokenReader, using the given options.
+func NewTokenReaderWithOptions(buf []byte, opts DecodeOptions) *TokenReader {
+	r := NewTokenReader(buf)
+	r.state.opts = opts
+	return r
+}
+
+// checkInteger checks that the integer or length prefix at offset, which
+// decoded to value, is in minimal form.
+func (s *decodeState) checkInteger(blob *[]byte, offset int64, value int64) error {
+	return s.checkIntegerByte(offset, (*blob)[offset], value)
+}
+
+// checkIntegerByte is checkInteger for the integer or length prefix starting
+// with typeByte.
+func (s *decodeState) checkIntegerByte(offset int64, typeByte byte, value int64) error {
+	if !s.opts.Strict {
+		return nil
+	}
+	if int(typeByte>>4)+1 != int(integerSize(value)) {
+		err := newDecodeError(offset, ErrNonCanonical)
+		err.Found = Kind(typeByte & 0x0f)
+		return err
+	}
+	return nil
+}
+
+// checkText checks that the key or string starting at offset is valid UTF-8.
+func (s *decodeState) checkText(offset int64, text string) error {
+	if !s.opts.Strict {
+		return nil
+	}
+	if !utf8.ValidString(text) {
+		return newDecodeError(offset, ErrInvalidUTF8)
+	}
+	return nil
+}
+
+func limitError(offset int64, limit string, max int64) error {
+	return newDecodeError(offset, &LimitError{
+		Limit: limit,
+		Max:   max,
+	})
+}
+
+// enter is called when decoding of a nested tree or array at offset starts.
+// leave must be called when it ends.
+func (s *decodeState) enter(offset int64) error {
+	s.depth++
+	if s.opts.MaxDepth > 0 && s.depth > s.opts.MaxDepth {
+		return limitError(offset, "MaxDepth", int64(s.opts.MaxDepth))
+	}
+	return nil
+}
+
+func (s *decodeState) leave() {
+	s.depth--
+}
+
+// countElement is called for every value, the value starts at offset.
+func (s *decodeState) countElement(offset int64) error {
+	s.elements++
+	if s.opts.MaxElements > 0 && s.elements > s.opts.MaxElements {
+		return limitError(offset, "MaxElements", s.opts.MaxElements)
+	}
+	return nil
+}
+
+// checkBlobSize checks the size of the blob or string starting at offset.
+func (s *decodeState) checkBlobSize(offset int64, size int64) error {
+	if s.opts.MaxBlobSize > 0 && size > s.opts.MaxBlobSize {
+		return limitError(offset, "MaxBlobSize", s.opts.MaxBlobSize)
+	}
+	return nil
+}
+
+// checkKeys checks the number of keys read so far in a tree, the last key
+// starts at offset.
+func (s *decodeState) checkKeys(offset int64, keys int) error {
+	if s.opts.MaxKeys > 0 && keys > s.opts.MaxKeys {
+		return limitError(offset, "MaxKeys", int64(s.opts.MaxKeys))
+	}
+	return nil
+}
+
+// checkDocumentSize checks the number of bytes in the document, or read so
+// far from a stream.
+func (s *decodeState) checkDocumentSize(size int64) error {
+	if s.opts.MaxDocumentSize > 0 && size > s.opts.MaxDocumentSize {
+		return limitError(s.opts.MaxDocumentSize, "MaxDocumentSize", s.opts.MaxDocumentSize)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package abit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// decodeAll decodes doc with every decoder and returns their errors.
+func decodeAll(doc []byte, opts DecodeOptions) []error {
+	_, err1 := NewABITObjectWithOptions(&doc, opts)
+	_, err2 := NewDecoderWithOptions(bytes.NewReader(doc), opts).Decode()
+	r := NewTokenReaderWithOptions(doc, opts)
+	var err3 error
+	for err3 == nil {
+		_, err3 = r.Next()
+	}
+	if err3 == io.EOF {
+		err3 = nil
+	}
+	return []error{err1, err2, err3}
+}
+
+func TestStrict(t *testing.T) {
+	strict := DecodeOptions{Strict: true}
+
+	doc := decoderTestTree().ToByteArray()
+	for _, err := range decodeAll(doc, strict) {
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	invalid := map[string]struct {
+		doc []byte
+		err error
+	}{
+		// 5 stored in 2 bytes
+		"integer": {[]byte{0x00, 'a', 0x12, 0x05, 0x00}, ErrNonCanonical},
+		// blob length 1 stored in 2 bytes
+		"length": {[]byte{0x00, 'a', 0x13, 0x01, 0x00, 0xff}, ErrNonCanonical},
+		// nested tree length stored in 2 bytes
+		"tree length": {[]byte{0x00, 'a', 0x16, 0x03, 0x00, 0x00, 'b', 0x00}, ErrNonCanonical},
+		"string":      {[]byte{0x00, 'a', 0x04, 0x02, 0xc3, 0x28}, ErrInvalidUTF8},
+		"key":         {[]byte{0x00, 0xff, 0x00}, ErrInvalidUTF8},
+	}
+	for name, c := range invalid {
+		for _, err := range decodeAll(c.doc, DecodeOptions{}) {
+			if err != nil {
+				t.Fatalf("%s: should be accepted when not strict: %s", name, err.Error())
+			}
+		}
+		for _, err := range decodeAll(c.doc, strict) {
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: expected %v, got %v", name, c.err, err)
+			}
+		}
+	}
+}
+
+func TestStrictRoundTrip(t *testing.T) {
+	strict := DecodeOptions{Strict: true}
+	for i := 0; i < 100000; i++ {
+		doc := []byte{0x00, 'a', byte(rand.Intn(256)), byte(rand.Intn(256)), byte(rand.Intn(256))}
+		doc = doc[:2+rand.Intn(4)]
+		tree, err := NewABITObjectWithOptions(&doc, strict)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(doc, tree.ToByteArray()) {
+			t.Fatalf("strict mode accepted non-canonical document % x", doc)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	// 1000 arrays nested inside each other
+	arr := NewABITArray()
+	for i := 0; i < 1000; i++ {
+		next := NewABITArray()
+		next.Add(*arr)
+		arr = next
+	}
+	deep, _ := NewABITObject(&[]byte{})
+	deep.Put("deep", *arr)
+
+	wide, _ := NewABITObject(&[]byte{})
+	for i := 0; i < 100; i++ {
+		wide.Put(strings.Repeat("k", i+1), int64(i))
+	}
+
+	big, _ := NewABITObject(&[]byte{})
+	big.Put("blob", randBytes(5000))
+	big.Put("string", strings.Repeat("s", 10))
+
+	cases := []struct {
+		tree  *ABITObject
+		opts  DecodeOptions
+		limit string
+	}{
+		{deep, DecodeOptions{MaxDepth: 10}, "MaxDepth"},
+		{deep, DecodeOptions{MaxElements: 500}, "MaxElements"},
+		{wide, DecodeOptions{MaxKeys: 50}, "MaxKeys"},
+		{wide, DecodeOptions{MaxElements: 99}, "MaxElements"},
+		{big, DecodeOptions{MaxBlobSize: 4096}, "MaxBlobSize"},
+		{big, DecodeOptions{MaxDocumentSize: 1024}, "MaxDocumentSize"},
+	}
+	for _, c := range cases {
+		doc := c.tree.ToByteArray()
+		for _, err := range decodeAll(doc, c.opts) {
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("%s: expected *LimitError, got %v", c.limit, err)
+			}
+			if limitErr.Limit != c.limit {
+				t.Fatalf("expected %s to be exceeded, got %s", c.limit, limitErr.Limit)
+			}
+		}
+
+		var out map[string]interface{}
+		if err := UnmarshalWithOptions(doc, &out, c.opts); !errors.Is(err, ErrLimitExceeded) {
+			t.Fatalf("%s: expected ErrLimitExceeded, got %v", c.limit, err)
+		}
+	}
+
+	// Exactly at the limits
+	opts := DecodeOptions{
+		MaxDepth:    1001,
+		MaxElements: 1001,
+		MaxKeys:     100,
+		MaxBlobSize: 5000,
+	}
+	for _, tree := range []*ABITObject{deep, wide, big} {
+		doc := tree.ToByteArray()
+		opts.MaxDocumentSize = int64(len(doc))
+		for _, err := range decodeAll(doc, opts) {
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package abit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Registry holds lexicons by id, so they can reference the definitions of
+// each other with "<id>#/defs/<name>", and documents naming their lexicon
+// in their TypeKey key can be validated without knowing the lexicon.
+//
+// A Registry is not safe for concurrent use while lexicons are added, not
+// even with Validate. Once every lexicon is added it can be read from
+// multiple goroutines.
+//
+// # Example
+//
+//	reg := abit.NewRegistry()
+//	err := reg.Add(address, person)
+//	if err != nil {
+//		// Handle missing lexicons or definitions here
+//	}
+type Registry struct {
+	lexicons map[string]*ABITLexicon
+}
+
+// TypeKey is the key of self-identifying documents holding the id of their
+// lexicon, see Registry.Validate.
+const TypeKey = "$type"
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		lexicons: map[string]*ABITLexicon{},
+	}
+}
+
+// Add adds lexicons to the registry, resolves their references to other
+// lexicons and composes the trees extending them. Every lexicon must have an
+// id and can only be added to one registry. Referenced lexicons must already
+// be in the registry or be added in the same call, so lexicons referencing
+// each other are added together. Lexicons with an id starting with "sha256:"
+// must have that id as their ContentID and may only reference other lexicons
+// by their ContentID, so the id covers every schema the lexicon depends on.
+//
+// error is nil on success, otherwise no lexicon is added.
+func (r *Registry) Add(lexicons ...*ABITLexicon) error {
+	added := map[string]*ABITLexicon{}
+	for _, lex := range lexicons {
+		if lex.id == "" {
+			return fmt.Errorf("lexicon without id can not be added to a registry")
+		}
+		if lex.registry != nil {
+			return fmt.Errorf("lexicon %q is already in a registry", lex.id)
+		}
+		if _, ok := r.lexicons[lex.id]; ok {
+			return fmt.Errorf("lexicon %q is already in the registry", lex.id)
+		}
+		if _, ok := added[lex.id]; ok {
+			return fmt.Errorf("lexicon %q is added twice", lex.id)
+		}
+		if strings.HasPrefix(lex.id, contentIDPrefix) {
+			if lex.ContentID() != lex.id {
+				return fmt.Errorf("lexicon %q does not match its content id", lex.id)
+			}
+			if id := lex.namedRef(); id != "" {
+				return fmt.Errorf("lexicon %q references lexicon %q, which is not a content id", lex.id, id)
+			}
+		}
+		added[lex.id] = lex
+	}
+
+	lookup := func(id string) *ABITLexicon {
+		if lex, ok := added[id]; ok {
+			return lex
+		}
+		return r.lexicons[id]
+	}
+	for i, lex := range lexicons {
+		if err := lex.link(lookup); err != nil {
+			for _, linked := range lexicons[:i] {
+				linked.unlink()
+			}
+			return fmt.Errorf("lexicon %q: %w", lex.id, err)
+		}
+	}
+	// Trees can extend trees of the other lexicons once all are linked.
+	for _, lex := range lexicons {
+		if err := lex.compose(true); err != nil {
+			for _, linked := range lexicons {
+				linked.unlink()
+			}
+			return fmt.Errorf("lexicon %q: %w", lex.id, err)
+		}
+	}
+	for _, lex := range lexicons {
+		lex.registry = r
+		r.lexicons[lex.id] = lex
+	}
+	return nil
+}
+
+// namedRef returns the id of the first lexicon referenced by l that is not a
+// content id, or "" if there is none.
+func (l *ABITLexicon) namedRef() string {
+	found := ""
+	for _, name := range sortedDefNames(l.defs) {
+		l.defs[name].walk(func(n *lexNode) {
+			if found == "" && n.ref != nil && n.ref.id != "" && n.ref.id != l.id && !strings.HasPrefix(n.ref.id, contentIDPrefix) {
+				found = n.ref.id
+			}
+		})
+	}
+	return found
+}
+
+// Lexicon returns the lexicon with the given id.
+//
+//   - Returns the lexicon and true if it is in the registry, nil and false otherwise.
+func (r *Registry) Lexicon(id string) (*ABITLexicon, bool) {
+	lex, ok := r.lexicons[id]
+	return lex, ok
+}
+
+// IDs returns the ids of the lexicons in the registry, sorted.
+func (r *Registry) IDs() []string {
+	ids := make([]string, 0, len(r.lexicons))
+	for id := range r.lexicons {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Validate checks a self-identifying document against the lexicon in the
+// registry named by its TypeKey key, and returns every violation, or nil if
+// the document matches. The TypeKey key is not part of the schema of the
+// lexicon. A document without the key, or naming a lexicon that is not in
+// the registry, gives a single error at the key.
+//
+// # Example
+//
+//	for _, err := range reg.Validate(doc) {
+//		fmt.Println(err.Path, err.Rule, err)
+//	}
+func (r *Registry) Validate(doc *ABITObject) []ValidationError {
+	if doc.dataType != 0b0110 {
+		return []ValidationError{{
+			Rule:     RuleTypeMismatch,
+			Expected: KindTree.String(),
+			Found:    Kind(doc.dataType),
+		}}
+	}
+	key, ok := doc.tree[TypeKey]
+	if !ok {
+		return []ValidationError{{
+			Path:     TypeKey,
+			Rule:     RuleMissingKey,
+			Expected: KindString.String(),
+			Found:    KindInvalid,
+		}}
+	}
+	if key.dataType != 0b0100 {
+		return []ValidationError{{
+			Path:     TypeKey,
+			Rule:     RuleTypeMismatch,
+			Expected: KindString.String(),
+			Found:    Kind(key.dataType),
+		}}
+	}
+	lex, ok := r.lexicons[*key.text]
+	if !ok {
+		return []ValidationError{{
+			Path:       TypeKey,
+			Rule:       RuleConstraint,
+			Constraint: TypeKey,
+			Expected:   KindString.String(),
+			Found:      KindString,
+			Detail:     fmt.Sprintf("lexicon %q is not in the registry", *key.text),
+		}}
+	}
+	return lex.Validate(withoutKey(doc, TypeKey))
+}
+
+// Matches reports whether a self-identifying document matches the lexicon
+// named by its TypeKey key, see Validate.
+func (r *Registry) Matches(doc *ABITObject) bool {
+	return len(r.Validate(doc)) == 0
+}
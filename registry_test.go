@@ -0,0 +1,396 @@
+package abit
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLexiconRecursive(t *testing.T) {
+	lex := InitLexicon(`{
+		"$lexicon": 1,
+		"id": "com.example.thread",
+		"defs": {
+			"main": {"title": "string", "comments": {"type": "array", "items": {"$ref": "#/defs/comment"}}},
+			"comment": {"text": "string", "replies?": {"type": "array", "items": {"$ref": "com.example.thread#/defs/comment"}}}
+		}
+	}`)
+	if lex.ID() != "com.example.thread" {
+		t.Fatalf("unexpected id %q", lex.ID())
+	}
+
+	comment := func(text interface{}, replies ...ABITObject) ABITObject {
+		tree, _ := NewABITObject(&[]byte{})
+		tree.Put("text", text)
+		if len(replies) > 0 {
+			arr := NewABITArray()
+			for _, reply := range replies {
+				arr.Add(reply)
+			}
+			tree.Put("replies", *arr)
+		}
+		return *tree
+	}
+	thread := func(comments ...ABITObject) *ABITObject {
+		tree, _ := NewABITObject(&[]byte{})
+		tree.Put("title", "meow")
+		arr := NewABITArray()
+		for _, c := range comments {
+			arr.Add(c)
+		}
+		tree.Put("comments", *arr)
+		return tree
+	}
+
+	if !lex.Matches(thread(comment("a", comment("b", comment("c"))), comment("d"))) {
+		t.Fatal("nested comments don't match")
+	}
+	errs := lex.Validate(thread(comment("a", comment("b", comment(int64(1))))))
+	if len(errs) != 1 || errs[0].Path != "comments[0].replies[0].replies[0].text" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	doc := lex.ToByteArray()
+	parsed, err := ParseLexicon(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.ToByteArray(), doc) || !parsed.Matches(thread(comment("a", comment("b")))) {
+		t.Fatal("lexicon changed after encoding and parsing")
+	}
+
+	self := InitLexicon(`{"$lexicon": 1, "name": "string", "child?": {"$ref": "#/defs/main"}}`)
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("name", "a")
+	child, _ := NewABITObject(&[]byte{})
+	child.Put("name", "b")
+	tree.Put("child", *child)
+	if !self.Matches(tree) {
+		t.Fatal("reference to main doesn't match")
+	}
+
+	for _, invalid := range []string{
+		`{"$lexicon": 1, "defs": {"a": "string"}}`,
+		`{"$lexicon": 1, "defs": {"main": "string"}}`,
+		`{"$lexicon": 1, "id": "", "defs": {"main": {}}}`,
+		`{"$lexicon": 1, "id": "a#b", "defs": {"main": {}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"a": {"$ref": "#/defs/missing"}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"a": {"$ref": "#/definitions/a"}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"a": {"$ref": ""}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"a": {"$ref": 1}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"a": {"$ref": "#/defs/b"}}, "b": {"$ref": "#/defs/c"}, "c": {"$ref": "#/defs/b"}}}`,
+	} {
+		if _, err := ParseLexiconJSON([]byte(invalid)); err == nil {
+			t.Fatalf("invalid lexicon %s parsed", invalid)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	address := parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.address", "defs": {"main": {"city": "string"}, "zip": {"type": "string", "pattern": "^[0-9]+$"}}}`)
+	person := parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.person", "defs": {"main": {
+		"home": {"$ref": "com.example.address"},
+		"zip": {"$ref": "com.example.address#/defs/zip"}
+	}}}`)
+
+	tree, _ := NewABITObject(&[]byte{})
+	home, _ := NewABITObject(&[]byte{})
+	home.Put("city", "Stockholm")
+	tree.Put("home", *home)
+	tree.Put("zip", "12345")
+
+	errs := person.Validate(tree)
+	if len(errs) != 2 || errs[0].Constraint != "$ref" {
+		t.Fatalf("unresolved references gave errors %v", errs)
+	}
+
+	reg := NewRegistry()
+	if err := reg.Add(person); err == nil {
+		t.Fatal("lexicon with unknown reference added")
+	}
+	if err := reg.Add(parseTestLexicon(t, `{}`)); err == nil {
+		t.Fatal("lexicon without id added")
+	}
+	if err := reg.Add(address, person); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Add(parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.address", "defs": {"main": {}}}`)); err == nil {
+		t.Fatal("lexicon added twice")
+	}
+	if err := NewRegistry().Add(address); err == nil {
+		t.Fatal("lexicon added to two registries")
+	}
+	if lex, ok := reg.Lexicon("com.example.person"); !ok || lex != person {
+		t.Fatal("lexicon not found in registry")
+	}
+	if ids := reg.IDs(); len(ids) != 2 || ids[0] != "com.example.address" {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+
+	if !person.Matches(tree) {
+		t.Fatalf("document doesn't match: %v", person.Validate(tree))
+	}
+	tree.Put("zip", "123a")
+	if person.Matches(tree) {
+		t.Fatal("document matches with invalid zip")
+	}
+
+	a := parseTestLexicon(t, `{"$lexicon": 1, "id": "a", "defs": {"main": {}, "x": {"$ref": "b#/defs/x"}}}`)
+	b := parseTestLexicon(t, `{"$lexicon": 1, "id": "b", "defs": {"main": {}, "x": {"$ref": "a#/defs/x"}}}`)
+	if err := NewRegistry().Add(a, b); err == nil {
+		t.Fatal("reference cycle across lexicons added")
+	}
+	c := parseTestLexicon(t, `{"$lexicon": 1, "id": "c", "defs": {"main": {"d?": {"$ref": "d"}}}}`)
+	d := parseTestLexicon(t, `{"$lexicon": 1, "id": "d", "defs": {"main": {"c?": {"$ref": "c"}}}}`)
+	if err := NewRegistry().Add(c, d); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryValidate(t *testing.T) {
+	post := parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.post", "defs": {"main": {"text": "string", "reply?": {"$ref": "com.example.post#/defs/main"}}}}`)
+	reg := NewRegistry()
+	if err := reg.Add(post); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("text", "meow")
+	want := []ValidationError{{Path: "$type", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid}}
+	if errs := reg.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+	tree.Put(TypeKey, int64(1))
+	want = []ValidationError{{Path: "$type", Rule: RuleTypeMismatch, Expected: "string", Found: KindInteger}}
+	if errs := reg.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+	tree.Put(TypeKey, "com.example.other")
+	want = []ValidationError{{Path: "$type", Rule: RuleConstraint, Constraint: "$type", Expected: "string", Found: KindString, Detail: `lexicon "com.example.other" is not in the registry`}}
+	if errs := reg.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+	tree.Put(TypeKey, "com.example.post")
+	if !reg.Matches(tree) {
+		t.Fatalf("document doesn't match: %v", reg.Validate(tree))
+	}
+	if !tree.Has(TypeKey) {
+		t.Fatal("type key removed from document")
+	}
+	tree.Put("text", int64(1))
+	if errs := reg.Validate(tree); len(errs) != 1 || errs[0].Path != "text" {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	same := parseTestLexicon(t, `{"$lexicon": 1, "defs": {"main": {"reply?": {"$ref": "#/defs/main"}, "text": "string"}}}`)
+	id := post.ContentID()
+	if !strings.HasPrefix(id, "sha256:") || len(id) != len("sha256:")+64 || same.ContentID() != id {
+		t.Fatalf("content ids %s and %s differ", id, same.ContentID())
+	}
+	if parseTestLexicon(t, `{"text": "string|null"}`).ContentID() == id {
+		t.Fatal("different lexicons have the same content id")
+	}
+	hashed := same.WithContentID()
+	if hashed.ID() != id || hashed.ContentID() != id {
+		t.Fatalf("lexicon has id %s, want %s", hashed.ID(), id)
+	}
+	if err := reg.Add(hashed); err != nil {
+		t.Fatal(err)
+	}
+	tree.Put(TypeKey, id)
+	tree.Put("text", "meow")
+	if !reg.Matches(tree) {
+		t.Fatalf("document doesn't match: %v", reg.Validate(tree))
+	}
+	forged := parseTestLexicon(t, `{"$lexicon": 1, "id": "`+id+`", "defs": {"main": {"text": "integer"}}}`)
+	if err := NewRegistry().Add(forged); err == nil {
+		t.Fatal("lexicon with a wrong content id added")
+	}
+	named := parseTestLexicon(t, `{"$lexicon": 1, "defs": {"main": {"post": {"$ref": "com.example.post"}}}}`).WithContentID()
+	if err := reg.Add(named); err == nil || !strings.Contains(err.Error(), "not a content id") {
+		t.Fatalf("lexicon with a content id referencing a named lexicon gave error %v", err)
+	}
+	if err := reg.Add(parseTestLexicon(t, `{"$lexicon": 1, "defs": {"main": {"post": {"$ref": "`+id+`"}}}}`).WithContentID()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLexiconExtends(t *testing.T) {
+	lex := parseTestLexicon(t, `{"$lexicon": 1, "defs": {
+		"main": {"type": "tree", "extends": [{"$ref": "#/defs/named"}, {"$ref": "#/defs/dated"}], "keys": {"name": {"type": "string", "maxLength": 4}, "tags?": {"type": "array", "items": "string"}}},
+		"named": {"name": "string|null", "note?": "string"},
+		"dated": {"created": "integer", "note?": "string"},
+		"both": {"allOf": [{"$ref": "#/defs/named"}, {"$ref": "#/defs/dated"}]}
+	}}`)
+
+	tree, _ := NewABITObject(&[]byte{})
+	tree.Put("name", "meow")
+	tree.Put("created", int64(1))
+	tree.Put("note", "a")
+	if errs := lex.Validate(tree); len(errs) != 0 {
+		t.Fatalf("document doesn't match: %v", errs)
+	}
+	doc := tree.ToByteArray()
+	if err := lex.Compile().ValidateBytes(doc); err != nil {
+		t.Fatal(err)
+	}
+	tree.Put("name", "meowmeow")
+	tree.Remove("created")
+	want := []ValidationError{
+		{Path: "name", Rule: RuleConstraint, Constraint: "maxLength", Expected: "string", Found: KindString, Detail: "length 8 is greater than 4"},
+		{Path: "created", Rule: RuleMissingKey, Expected: "integer", Found: KindInvalid},
+	}
+	if errs := lex.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+
+	const source = `{"$lexicon":1,"defs":{"both":{"allOf":[{"$ref":"#/defs/named"},{"$ref":"#/defs/dated"}]},"dated":{"created":"integer","note?":"string"},` +
+		`"main":{"extends":[{"$ref":"#/defs/named"},{"$ref":"#/defs/dated"}],"keys":{"name":{"maxLength":4,"type":"string"},"tags?":{"items":"string","type":"array"}},"type":"tree"},` +
+		`"named":{"name":"null|string","note?":"string"}}}`
+	if got := string(lex.ToJSON()); got != source {
+		t.Fatalf("got source %s", got)
+	}
+	parsed, err := ParseLexicon(lex.ToByteArray())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parsed.ToByteArray(), lex.ToByteArray()) {
+		t.Fatal("lexicon changed after encoding and parsing")
+	}
+
+	flat, err := lex.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const flatMain = `"main":{"created":"integer","name":{"maxLength":4,"type":"string"},"note?":"string","tags?":{"items":"string","type":"array"}}`
+	if got := string(flat.ToJSON()); !strings.Contains(got, flatMain) || strings.Contains(got, "extends") || strings.Contains(got, "allOf") {
+		t.Fatalf("got flat source %s", got)
+	}
+
+	for _, invalid := range []string{
+		`{"$lexicon": 1, "defs": {"main": {"allOf": [{"a": "string"}, {"a": "integer"}]}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": [{"a": "string"}, {"a?": "string"}]}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"type": "tree", "extends": {"a": "string"}, "keys": {"a": "string|null"}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"type": "tree", "extends": {"a": "string"}, "keys": {"a?": "string"}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": ["string"]}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": [{"oneOf": [{}, "null"]}]}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": []}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": {}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"allOf": [{"$ref": "#/defs/a"}]}, "a": {"allOf": [{"$ref": "#/defs/main"}]}}}`,
+		`{"$lexicon": 1, "defs": {"main": {"type": "map", "values": "string", "extends": {}}}}`,
+		`{"$lexicon": 1, "defs": {"main": {}, "a": {"type": "string", "extends": {}}}}`,
+	} {
+		if _, err := ParseLexiconJSON([]byte(invalid)); err == nil {
+			t.Fatalf("invalid lexicon %s parsed", invalid)
+		}
+	}
+
+	const parent = `"base": {"name": {"type": "string", "maxLength": 3}, "addr": {"street": "string", "zip?": "integer"}, "kind?": {"type": "string", "enum": ["a", "b"]}}`
+	for _, keys := range []string{
+		`"name": {"type": "string", "maxLength": 2}`,
+		`"name": {"type": "string", "minLength": 1, "maxLength": 3, "enum": ["ab"]}`,
+		`"addr": {"street": {"type": "string", "maxLength": 10}}`,
+		`"addr": {"street": "string", "zip": {"type": "integer", "minimum": 0}}`,
+		`"kind": {"type": "string", "enum": ["a"]}`,
+		`"kind?": {"oneOf": [{"type": "string", "const": "a"}, {"type": "string", "const": "b"}]}`,
+	} {
+		lexicon := `{"$lexicon": 1, "defs": {"main": {"type": "tree", "extends": {"$ref": "#/defs/base"}, "keys": {` + keys + `}}, ` + parent + `}}`
+		if _, err := ParseLexiconJSON([]byte(lexicon)); err != nil {
+			t.Fatalf("overlay %s does not narrow its parent: %v", keys, err)
+		}
+	}
+	for _, keys := range []string{
+		`"name": "string"`,
+		`"name": {"type": "string", "maxLength": 4}`,
+		`"name": {"type": "string", "maxLength": 3, "pattern": "^a"}, "addr": {"street": "string", "zip?": "string"}`,
+		`"addr": {"zip": "integer"}`,
+		`"addr": {"street": "string", "city": "string"}`,
+		`"addr": {"type": "map", "values": "string"}`,
+		`"kind": "string"`,
+		`"kind": {"type": "string", "enum": ["a", "c"]}`,
+		`"kind?": {"anyOf": [{"type": "string", "const": "a"}, "string"]}`,
+	} {
+		lexicon := `{"$lexicon": 1, "defs": {"main": {"type": "tree", "extends": {"$ref": "#/defs/base"}, "keys": {` + keys + `}}, ` + parent + `}}`
+		if _, err := ParseLexiconJSON([]byte(lexicon)); err == nil || !strings.Contains(err.Error(), "allows more than its parent") {
+			t.Fatalf("overlay %s loosening its parent gave error %v", keys, err)
+		}
+	}
+	recursive := `{"$lexicon": 1, "defs": {
+		"main": {"type": "tree", "extends": {"list": {"$ref": "#/defs/list"}}, "keys": {"list": {"$ref": "#/defs/positive"}}},
+		"list": {"value": "integer", "next?": {"$ref": "#/defs/list"}},
+		"positive": {"value": {"type": "integer", "minimum": 1}, "next?": {"$ref": "#/defs/positive"}}
+	}}`
+	if _, err := ParseLexiconJSON([]byte(recursive)); err != nil {
+		t.Fatalf("recursive overlay does not narrow its parent: %v", err)
+	}
+
+	base := parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.base", "defs": {"main": {"id": "string", "owner?": {"$ref": "#/defs/owner"}}, "owner": {"name": "string"}}}`)
+	post := parseTestLexicon(t, `{"$lexicon": 1, "id": "com.example.post", "defs": {"main": {"type": "tree", "extends": {"$ref": "com.example.base"}, "keys": {"text": "string"}}}}`)
+	tree, _ = NewABITObject(&[]byte{})
+	tree.Put("id", "1")
+	tree.Put("text", "meow")
+	want = []ValidationError{
+		{Rule: RuleConstraint, Constraint: "extends", Found: KindTree, Detail: "parents of the tree are not resolved"},
+	}
+	if errs := post.Validate(tree); !reflect.DeepEqual(errs, want) {
+		t.Fatalf("got errors %v, want %v", errs, want)
+	}
+	if _, err := post.Flatten(); err == nil {
+		t.Fatal("lexicon with unresolved parent flattened")
+	}
+	if err := NewRegistry().Add(base, parseTestLexicon(t, `{"$lexicon": 1, "id": "x", "defs": {"main": {"type": "tree", "extends": {"$ref": "com.example.base"}, "keys": {"id": "integer"}}}}`)); err == nil {
+		t.Fatal("lexicon widening an inherited key added")
+	}
+	if post.Matches(tree) {
+		t.Fatal("rejected lexicon stayed composed")
+	}
+	reg := NewRegistry()
+	if err := reg.Add(base, post); err != nil {
+		t.Fatal(err)
+	}
+	if errs := post.Validate(tree); len(errs) != 0 {
+		t.Fatalf("document doesn't match: %v", errs)
+	}
+	flat, err = post.Flatten()
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner, _ := NewABITObject(&[]byte{})
+	owner.Put("name", int64(1))
+	tree.Put("owner", *owner)
+	if errs := flat.Validate(tree); len(errs) != 1 || errs[0].Path != "owner.name" {
+		t.Fatalf("unexpected errors %v for flat lexicon %s", errs, flat.ToJSON())
+	}
+}
+
+func TestLexiconBaselineKeywords(t *testing.T) {
+	cases := []struct {
+		lexicon string
+		doc     string
+	}{
+		{`{"id": "string", "defs": "string"}`, `{"id": "a", "defs": "b"}`},
+		{`{"defs": {"a": "string"}}`, `{"defs": {"a": "b"}}`},
+		{`{"a": {"$ref": "string"}}`, `{"a": {"$ref": "#/defs/b"}}`},
+		{`{"a": {"oneOf": ["string"], "discriminator": "string"}}`, `{"a": {"oneOf": ["b"], "discriminator": "$type"}}`},
+		{`{"a": {"anyOf": ["string", "integer"]}}`, `{"a": {"anyOf": ["b", 1]}}`},
+		{`{"a": {"allOf": ["string"]}}`, `{"a": {"allOf": ["b"]}}`},
+	}
+	for i, c := range cases {
+		lex := parseTestLexicon(t, c.lexicon)
+		if lex.ID() != "" {
+			t.Fatalf("case %d: baseline lexicon has id %q", i, lex.ID())
+		}
+		doc, err := FromJSON([]byte(c.doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := lex.Validate(doc); len(errs) != 0 {
+			t.Fatalf("case %d: keywords must stay keys without \"$lexicon\", got %v", i, errs)
+		}
+		parsed, err := ParseLexicon(lex.ToByteArray())
+		if err != nil || !parsed.Matches(doc) {
+			t.Fatalf("case %d: lexicon changed after encoding and parsing: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package abit
+
+import (
+	"fmt"
+	"io"
+)
+
+// TokenKind identifies the kind of a Token.
+type TokenKind uint8
+
+const (
+	// TokenTreeStart starts a tree. The document itself starts with one.
+	TokenTreeStart TokenKind = iota
+	// TokenArrayStart starts an array.
+	TokenArrayStart
+	// TokenEnd ends the innermost tree or array.
+	TokenEnd
+	// TokenKey is a key in a tree, it is followed by the value of the key.
+	TokenKey
+	// TokenNull is a null value.
+	TokenNull
+	// TokenBool is a boolean value.
+	TokenBool
+	// TokenInteger is an integer value.
+	TokenInteger
+	// TokenBlob is a blob value.
+	TokenBlob
+	// TokenString is a string value.
+	TokenString
+)
+
+func (k TokenKind) String() string {
+	switch k {
+	case TokenTreeStart:
+		return "TreeStart"
+	case TokenArrayStart:
+		return "ArrayStart"
+	case TokenEnd:
+		return "End"
+	case TokenKey:
+		return "Key"
+	case TokenNull:
+		return "Null"
+	case TokenBool:
+		return "Bool"
+	case TokenInteger:
+		return "Integer"
+	case TokenBlob:
+		return "Blob"
+	case TokenString:
+		return "String"
+	}
+	return fmt.Sprintf("TokenKind(%d)", uint8(k))
+}
+
+// Token is a single element of an ABIT document returned by TokenReader.Next.
+type Token struct {
+	Kind TokenKind
+	// Offset is the position of the first byte of the element in the
+	// document. For TokenEnd it is the position just past the container.
+	Offset int64
+	// Size is the number of bytes used by the element, including its type
+	// byte and length prefix. It is 0 for TokenEnd.
+	Size int64
+
+	// Key is set for TokenKey.
+	Key string
+	// Bool is set for TokenBool.
+	Bool bool
+	// Integer is set for TokenInteger.
+	Integer int64
+	// Bytes is set for TokenBlob and TokenString. It is a sub-slice of the
+	// document and must not be modified.
+	Bytes []byte
+}
+
+type tokenFrame struct {
+	end       int64
+	tree      bool
+	segment   string
+	lastKey   string
+	wantValue bool
+	index     int
+	keys      int
+}
+
+// TokenReader reads an ABIT document one token at a time, without building
+// an ABITObject.
+//
+//	r := abit.NewTokenReader(doc)
+//	for {
+//		tok, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		if err != nil {
+//			// Handle invalid document here
+//		}
+//		// Code handling the token here
+//	}
+type TokenReader struct {
+	buf     []byte
+	state   decodeState
+	offset  int64
+	stack   []tokenFrame
+	started bool
+	err     error
+}
+
+// NewTokenReader returns a TokenReader reading the document in buf.
+func NewTokenReader(buf []byte) *TokenReader {
+	return &TokenReader{
+		buf: buf,
+	}
+}
+
+// Depth returns the number of trees and arrays currently open, including
+// the document itself.
+func (r *TokenReader) Depth() int {
+	return len(r.stack)
+}
+
+// Next returns the next token in the document.
+//
+// The document starts with TokenTreeStart and every TokenTreeStart or
+// TokenArrayStart is matched by a TokenEnd. Inside a tree every value is
+// preceded by a TokenKey. After the last TokenEnd, Next returns io.EOF.
+// Errors in the document are returned as a *DecodeError.
+func (r *TokenReader) Next() (Token, error) {
+	if r.err != nil {
+		return Token{}, r.err
+	}
+	tok, err := r.next()
+	if err != nil {
+		if err != io.EOF {
+			err = locateDecodeError(err, 0, r.path())
+		}
+		r.err = err
+	}
+	return tok, err
+}
+
+// path returns the path to the element the reader is at.
+func (r *TokenReader) path() string {
+	path := ""
+	for _, frame := range r.stack {
+		path = appendPath(path, frame.segment)
+	}
+	if len(r.stack) > 0 {
+		top := r.stack[len(r.stack)-1]
+		if !top.tree {
+			path = appendPath(path, indexSegment(top.index))
+		} else if top.wantValue {
+			path = appendPath(path, top.lastKey)
+		}
+	}
+	return path
+}
+
+func (r *TokenReader) next() (Token, error) {
+	if !r.started {
+		r.started = true
+		if err := r.state.checkDocumentSize(int64(len(r.buf))); err != nil {
+			return Token{}, err
+		}
+		r.stack = append(r.stack, tokenFrame{
+			end:  int64(len(r.buf)),
+			tree: true,
+		})
+		return Token{
+			Kind:   TokenTreeStart,
+			Offset: 0,
+			Size:   int64(len(r.buf)),
+		}, nil
+	}
+	if len(r.stack) == 0 {
+		return Token{}, io.EOF
+	}
+
+	top := &r.stack[len(r.stack)-1]
+	if r.offset == top.end {
+		if top.wantValue {
+			return Token{}, newDecodeError(r.offset, ErrTruncated)
+		}
+		r.pop()
+		return Token{
+			Kind:   TokenEnd,
+			Offset: r.offset,
+		}, nil
+	}
+	if r.offset > top.end {
+		return Token{}, newDecodeError(r.offset, ErrOverrun)
+	}
+
+	// Bounds checks are made against the end of the container.
+	blob := r.buf[:top.end]
+	start := r.offset
+
+	if top.tree && !top.wantValue {
+		key, offset, err := decodeKey(&blob, start)
+		if err != nil {
+			return Token{}, err
+		}
+		lastKey := top.lastKey
+		top.lastKey = key
+		top.wantValue = true
+		if !keyCompare(lastKey, key) {
+			return Token{}, newDecodeError(start, ErrKeyOrder)
+		}
+		if err := r.state.checkText(start, key); err != nil {
+			return Token{}, err
+		}
+		top.keys++
+		if err := r.state.checkKeys(start, top.keys); err != nil {
+			return Token{}, err
+		}
+		r.offset = offset
+		return Token{
+			Kind:   TokenKey,
+			Offset: start,
+			Size:   offset - start,
+			Key:    key,
+		}, nil
+	}
+	typ, err := decodeType(&blob, start)
+	if err != nil {
+		return Token{}, err
+	}
+	if err := r.state.countElement(start); err != nil {
+		return Token{}, err
+	}
+	tok := Token{
+		Offset: start,
+	}
+	var offset int64
+	switch typ {
+	case 0b0000:
+		tok.Kind = TokenNull
+		offset, err = decodeNull(&blob, start)
+	case 0b0001:
+		tok.Kind = TokenBool
+		tok.Bool, offset, err = decodeBoolean(&blob, start)
+	case 0b0010:
+		tok.Kind = TokenInteger
+		tok.Integer, offset, err = decodeInteger(&blob, start, 8)
+		if err == nil {
+			err = r.state.checkInteger(&blob, start, tok.Integer)
+		}
+	case 0b0011:
+		tok.Kind = TokenBlob
+		tok.Bytes, offset, err = decodeBlob(&blob, start)
+		if err == nil {
+			err = r.state.checkInteger(&blob, start, int64(len(tok.Bytes)))
+		}
+		if err == nil {
+			err = r.state.checkBlobSize(start, int64(len(tok.Bytes)))
+		}
+	case 0b0100:
+		tok.Kind = TokenString
+		tok.Bytes, offset, err = decodeBlob(&blob, start)
+		if err == nil {
+			err = r.state.checkInteger(&blob, start, int64(len(tok.Bytes)))
+		}
+		if err == nil {
+			err = r.state.checkBlobSize(start, int64(len(tok.Bytes)))
+		}
+		if err == nil {
+			err = r.state.checkText(start, string(tok.Bytes))
+		}
+	case 0b0101, 0b0110:
+		var length int64
+		length, offset, err = decodeLength(&blob, start)
+		if err != nil {
+			return Token{}, err
+		}
+		if err := r.state.checkInteger(&blob, start, length); err != nil {
+			return Token{}, err
+		}
+		if err := r.state.enter(start); err != nil {
+			return Token{}, err
+		}
+		tok.Kind = TokenArrayStart
+		if typ == 0b0110 {
+			tok.Kind = TokenTreeStart
+		}
+		segment := top.lastKey
+		if !top.tree {
+			segment = indexSegment(top.index)
+		}
+		top.valueDone()
+		r.stack = append(r.stack, tokenFrame{
+			end:     offset + length,
+			tree:    typ == 0b0110,
+			segment: segment,
+		})
+		tok.Size = offset + length - start
+		r.offset = offset
+		return tok, nil
+	default:
+		err := newDecodeError(start, ErrInvalidType)
+		err.Found = Kind(typ)
+		return Token{}, err
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	top.valueDone()
+	tok.Size = offset - start
+	r.offset = offset
+	return tok, nil
+}
+
+// valueDone moves the frame past a value that has been read.
+func (f *tokenFrame) valueDone() {
+	if f.tree {
+		f.wantValue = false
+	} else {
+		f.index++
+	}
+}
+
+// Skip skips the rest of the innermost open tree or array, including its
+// TokenEnd, using the length prefix of the container.
+//
+// Calling Skip right after Next returns TokenTreeStart or TokenArrayStart
+// skips the whole container without decoding any of its contents.
+//
+// # Example
+//
+//	tok, _ := r.Next()
+//	if tok.Kind == abit.TokenTreeStart {
+//		// Not interested in this subtree.
+//		r.Skip()
+//	}
+func (r *TokenReader) Skip() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.stack) == 0 {
+		return fmt.Errorf("no open tree or array to skip")
+	}
+	r.offset = r.stack[len(r.stack)-1].end
+	r.pop()
+	return nil
+}
+
+// pop closes the innermost open tree or array.
+func (r *TokenReader) pop() {
+	r.stack = r.stack[:len(r.stack)-1]
+	if len(r.stack) > 0 {
+		r.state.leave()
+	}
+}
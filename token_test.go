@@ -0,0 +1,120 @@
+package abit
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// buildFromTokens rebuilds the value started by a TokenTreeStart or
+// TokenArrayStart that has just been read.
+func buildFromTokens(t *testing.T, r *TokenReader, tree bool) interface{} {
+	t.Helper()
+	obj, _ := NewABITObject(&[]byte{})
+	arr := NewABITArray()
+	var key string
+	for {
+		tok, err := r.Next()
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var value interface{}
+		switch tok.Kind {
+		case TokenEnd:
+			if tree {
+				return *obj
+			}
+			return *arr
+		case TokenKey:
+			key = tok.Key
+			continue
+		case TokenNull:
+			value = Null{}
+		case TokenBool:
+			value = tok.Bool
+		case TokenInteger:
+			value = tok.Integer
+		case TokenBlob:
+			value = tok.Bytes
+		case TokenString:
+			value = string(tok.Bytes)
+		case TokenTreeStart:
+			value = buildFromTokens(t, r, true)
+		case TokenArrayStart:
+			value = buildFromTokens(t, r, false)
+		}
+		if tree {
+			obj.Put(key, value)
+		} else {
+			arr.Add(value)
+		}
+	}
+}
+
+func TestTokenReader(t *testing.T) {
+	doc := decoderTestTree().ToByteArray()
+
+	r := NewTokenReader(doc)
+	tok, err := r.Next()
+	if err != nil || tok.Kind != TokenTreeStart {
+		t.Fatal("document should start with TokenTreeStart")
+	}
+	tree := buildFromTokens(t, r, true).(ABITObject)
+	if !bytes.Equal(doc, tree.ToByteArray()) {
+		t.Fatal("abit not equal")
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestTokenReaderSkip(t *testing.T) {
+	doc := decoderTestTree().ToByteArray()
+
+	r := NewTokenReader(doc)
+	var keys []string
+	for {
+		tok, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		switch tok.Kind {
+		case TokenKey:
+			keys = append(keys, tok.Key)
+		case TokenTreeStart, TokenArrayStart:
+			if r.Depth() > 1 {
+				if int(tok.Offset+tok.Size) > len(doc) {
+					t.Fatal("container exceeds document")
+				}
+				if err := r.Skip(); err != nil {
+					t.Fatal(err.Error())
+				}
+			}
+		}
+	}
+
+	tree, _ := NewABITObject(&doc)
+	if len(keys) != len(tree.Keys()) {
+		t.Fatalf("expected only root keys, got %v", keys)
+	}
+}
+
+func TestTokenReaderInvalid(t *testing.T) {
+	for i := 0; i < 20000; i++ {
+		obj := randBytes(512)
+		r := NewTokenReader(obj)
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err == io.EOF {
+			if _, err := NewABITObject(&obj); err == nil {
+				continue
+			}
+			t.Fatal("token reader accepted an invalid document")
+		}
+	}
+}
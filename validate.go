@@ -0,0 +1,433 @@
+package abit
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationRule is the kind of rule of a lexicon that a document broke.
+type ValidationRule uint8
+
+const (
+	// RuleMissingKey means a required key of a tree is not in the document.
+	RuleMissingKey ValidationRule = iota + 1
+	// RuleUnexpectedKey means the document has a key the lexicon does not
+	// allow.
+	RuleUnexpectedKey
+	// RuleTypeMismatch means a value is not of any type the lexicon allows.
+	RuleTypeMismatch
+	// RuleConstraint means a value is of an allowed type but breaks a
+	// constraint such as maxLength.
+	RuleConstraint
+)
+
+// String returns a short name of the rule, such as "missing key".
+func (r ValidationRule) String() string {
+	switch r {
+	case RuleMissingKey:
+		return "missing key"
+	case RuleUnexpectedKey:
+		return "unexpected key"
+	case RuleTypeMismatch:
+		return "type mismatch"
+	case RuleConstraint:
+		return "constraint"
+	}
+	return fmt.Sprintf("rule(%d)", uint8(r))
+}
+
+// ValidationError describes one way in which a document does not follow a
+// lexicon.
+type ValidationError struct {
+	// Path leads to the value, such as items[2].price. It is empty for the
+	// document root.
+	Path string
+	// Rule is the rule that failed.
+	Rule ValidationRule
+	// Constraint is the keyword of the failed constraint, such as
+	// "maxLength", when Rule is RuleConstraint.
+	Constraint string
+	// Expected is the type allowed by the lexicon as written in lexicons,
+	// such as "string|null". It is empty for RuleUnexpectedKey.
+	Expected string
+	// Found is the kind of the value, or KindInvalid for RuleMissingKey.
+	Found Kind
+	// Detail explains a failed constraint, such as "length 6 is greater
+	// than 4".
+	Detail string
+}
+
+func (e ValidationError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Rule.String())
+	if e.Rule == RuleConstraint {
+		fmt.Fprintf(&b, " %s", e.Constraint)
+	}
+	fmt.Fprintf(&b, " at %s", pathOrRoot(e.Path))
+	switch {
+	case e.Detail != "":
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	case e.Expected != "" && e.Found != KindInvalid:
+		fmt.Fprintf(&b, " (expected %s, found %s)", e.Expected, e.Found)
+	case e.Expected != "":
+		fmt.Fprintf(&b, " (expected %s)", e.Expected)
+	case e.Found != KindInvalid:
+		fmt.Fprintf(&b, " (found %s)", e.Found)
+	}
+	return b.String()
+}
+
+// kindsString returns a set of kinds as written in lexicons, such as
+// "string|null".
+func kindsString(kinds uint8) string {
+	if kinds == lexAnyKinds {
+		return "any"
+	}
+	var names []string
+	for kind := KindNull; kind <= KindTree; kind++ {
+		if kinds&kindBit(kind) != 0 {
+			names = append(names, kind.String())
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Matches checks if the given ABITObject matches the schema in the lexicon.
+//
+//   - Returns true if matches, returns false otherwise.
+//
+// # Example:
+//
+//	if lex.Matches(doc) {
+//		// functions to handle the valid abit document here.
+//	}
+func (l *ABITLexicon) Matches(doc *ABITObject) bool {
+	return len(l.Validate(doc)) == 0
+}
+
+// Validate checks the given ABITObject against the schema in the lexicon and
+// returns every violation, or nil if the document matches.
+//
+// # Example:
+//
+//	for _, err := range lex.Validate(doc) {
+//		fmt.Println(err.Path, err.Rule, err)
+//	}
+func (l *ABITLexicon) Validate(doc *ABITObject) []ValidationError {
+	v := &validator{}
+	v.node(l.rootNode(), doc, "")
+	return v.errs
+}
+
+// validator collects the violations of a document.
+type validator struct {
+	errs []ValidationError
+}
+
+func (v *validator) constraint(node *lexNode, kind Kind, path string, keyword string, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{
+		Path:       path,
+		Rule:       RuleConstraint,
+		Constraint: keyword,
+		Expected:   kindsString(node.kinds),
+		Found:      kind,
+		Detail:     fmt.Sprintf(format, args...),
+	})
+}
+
+// bounds checks n against the bounds that are set. what names the measured
+// quantity, such as "length".
+func (v *validator) bounds(node *lexNode, kind Kind, path string, what string, n int64, min *int64, minKeyword string, max *int64, maxKeyword string) {
+	if min != nil && n < *min {
+		v.constraint(node, kind, path, minKeyword, "%s %d is less than %d", what, n, *min)
+	}
+	if max != nil && n > *max {
+		v.constraint(node, kind, path, maxKeyword, "%s %d is greater than %d", what, n, *max)
+	}
+}
+
+func (v *validator) node(node *lexNode, obj *ABITObject, path string) {
+	kind := Kind(obj.dataType)
+	if node = node.resolved(); node.ref != nil {
+		v.errs = append(v.errs, ValidationError{
+			Path:       path,
+			Rule:       RuleConstraint,
+			Constraint: "$ref",
+			Found:      kind,
+			Detail:     fmt.Sprintf("reference %s is not resolved", node.ref),
+		})
+		return
+	}
+	if node.union != nil {
+		v.union(node.union, obj, path)
+		return
+	}
+	if node.any {
+		return
+	}
+	if !node.accepts(kind) {
+		v.errs = append(v.errs, ValidationError{
+			Path:     path,
+			Rule:     RuleTypeMismatch,
+			Expected: kindsString(node.kinds),
+			Found:    kind,
+		})
+		return
+	}
+	switch obj.dataType {
+	case 0b0001: // Boolean
+		v.enum(node, kind, obj.boolean, path)
+	case 0b0010: // Integer
+		v.bounds(node, kind, path, "integer", obj.integer, node.minimum, "minimum", node.maximum, "maximum")
+		v.enum(node, kind, obj.integer, path)
+	case 0b0011: // Blob
+		v.bounds(node, kind, path, "size", int64(len(*obj.blob)), node.minSize, "minSize", node.maxSize, "maxSize")
+	case 0b0100: // String
+		v.string(node, *obj.text, path)
+	case 0b0101: // Array
+		v.array(node, obj, path)
+	case 0b0110: // Tree
+		v.tree(node, obj, path)
+	}
+}
+
+func (v *validator) string(node *lexNode, s string, path string) {
+	v.enum(node, KindString, s, path)
+	v.bounds(node, KindString, path, "length", int64(len(s)), node.minLength, "minLength", node.maxLength, "maxLength")
+	if node.pattern != nil && !node.pattern.MatchString(s) {
+		v.constraint(node, KindString, path, "pattern", "%q does not match %s", s, node.pattern)
+	}
+	if node.format != "" && !lexFormats[node.format](s) {
+		v.constraint(node, KindString, path, "format", "%q is not a valid %s", s, node.format)
+	}
+}
+
+func (v *validator) tree(node *lexNode, obj *ABITObject, path string) {
+	if node.extends != nil && node.fields == nil {
+		v.errs = append(v.errs, unresolvedParents(path))
+		return
+	}
+	for _, key := range sortedKeys(obj.tree) {
+		childPath := appendPath(path, key)
+		if field, ok := node.fields[key]; ok {
+			v.node(field.node, obj.tree[key], childPath)
+			continue
+		}
+		if node.additional == nil {
+			v.errs = append(v.errs, ValidationError{
+				Path:  childPath,
+				Rule:  RuleUnexpectedKey,
+				Found: Kind(obj.tree[key].dataType),
+			})
+			continue
+		}
+		v.key(node, key, childPath)
+		v.node(node.additional, obj.tree[key], childPath)
+	}
+	for _, key := range sortedFieldKeys(node.fields) {
+		if _, ok := obj.tree[key]; !ok && !node.fields[key].optional {
+			v.errs = append(v.errs, ValidationError{
+				Path:     appendPath(path, key),
+				Rule:     RuleMissingKey,
+				Expected: kindsString(node.fields[key].node.kindSet()),
+				Found:    KindInvalid,
+			})
+		}
+	}
+}
+
+// enum checks a boolean, integer or string against the values allowed by
+// enum or const.
+func (v *validator) enum(node *lexNode, kind Kind, value interface{}, path string) {
+	if node.enum == nil {
+		return
+	}
+	for _, allowed := range node.enum {
+		if allowed == value {
+			return
+		}
+	}
+	if node.isConst {
+		v.constraint(node, kind, path, "const", "%s is not %s", formatLexValue(value), formatLexValue(node.enum[0]))
+		return
+	}
+	allowed := make([]string, len(node.enum))
+	for i := range node.enum {
+		allowed[i] = formatLexValue(node.enum[i])
+	}
+	v.constraint(node, kind, path, "enum", "%s is not one of %s", formatLexValue(value), strings.Join(allowed, ", "))
+}
+
+// unresolvedParents returns the error for a tree at path with parents that
+// are not composed, because they are in a lexicon that is not resolved.
+func unresolvedParents(path string) ValidationError {
+	return ValidationError{
+		Path:       path,
+		Rule:       RuleConstraint,
+		Constraint: "extends",
+		Found:      KindTree,
+		Detail:     "parents of the tree are not resolved",
+	}
+}
+
+// formatLexValue formats a boolean, integer or string as written in JSON.
+func formatLexValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(value)
+}
+
+// key checks a key of a tree against the key constraints of the node.
+func (v *validator) key(node *lexNode, key string, path string) {
+	v.bounds(node, KindTree, path, "key length", int64(len(key)), node.minKeyLength, "minKeyLength", node.maxKeyLength, "maxKeyLength")
+	if node.keyPattern != nil && !node.keyPattern.MatchString(key) {
+		v.constraint(node, KindTree, path, "keyPattern", "key %q does not match %s", key, node.keyPattern)
+	}
+}
+
+func (v *validator) array(node *lexNode, obj *ABITObject, path string) {
+	values := obj.array.array
+	if node.items != nil {
+		v.bounds(node, KindArray, path, "count", int64(len(values)), node.minItems, "minItems", node.maxItems, "maxItems")
+		for i, item := range values {
+			v.node(node.items, item, appendPath(path, indexSegment(i)))
+		}
+		return
+	}
+	if len(node.tuple) != len(values) {
+		v.constraint(node, KindArray, path, "length", "tuple has %d values, expected %d", len(values), len(node.tuple))
+	}
+	for i := 0; i < len(node.tuple) && i < len(values); i++ {
+		v.node(node.tuple[i], values[i], appendPath(path, indexSegment(i)))
+	}
+}
+
+// union checks a value against the branches of a union. If no branch
+// matches, the errors of the closest branch are reported.
+func (v *validator) union(union *lexUnion, obj *ABITObject, path string) {
+	if union.discriminator != "" {
+		v.discriminated(union, obj, path)
+		return
+	}
+
+	var matched []string
+	var closest []ValidationError
+	kinds := uint8(0)
+	for i, branch := range union.branches {
+		kinds |= branch.kindSet()
+		sub := &validator{}
+		sub.node(branch, obj, path)
+		if len(sub.errs) == 0 {
+			if !union.exclusive {
+				return
+			}
+			matched = append(matched, strconv.Itoa(i))
+			continue
+		}
+		wrongKind := len(sub.errs) == 1 && sub.errs[0].Rule == RuleTypeMismatch && sub.errs[0].Path == path
+		if !wrongKind && (closest == nil || len(sub.errs) < len(closest)) {
+			closest = sub.errs
+		}
+	}
+	switch {
+	case len(matched) > 1:
+		v.errs = append(v.errs, ValidationError{
+			Path:       path,
+			Rule:       RuleConstraint,
+			Constraint: "oneOf",
+			Expected:   kindsString(kinds),
+			Found:      Kind(obj.dataType),
+			Detail:     fmt.Sprintf("value matches branches %s", strings.Join(matched, ", ")),
+		})
+	case len(matched) == 1:
+	case closest == nil:
+		v.errs = append(v.errs, ValidationError{
+			Path:     path,
+			Rule:     RuleTypeMismatch,
+			Expected: kindsString(kinds),
+			Found:    Kind(obj.dataType),
+		})
+	default:
+		v.errs = append(v.errs, closest...)
+	}
+}
+
+// discriminated checks a tree against the branch named by its discriminator
+// key, or the fallback if it names no branch. The discriminator key is not
+// part of the branch.
+func (v *validator) discriminated(union *lexUnion, obj *ABITObject, path string) {
+	if obj.dataType != 0b0110 {
+		v.errs = append(v.errs, ValidationError{
+			Path:     path,
+			Rule:     RuleTypeMismatch,
+			Expected: KindTree.String(),
+			Found:    Kind(obj.dataType),
+		})
+		return
+	}
+	keyPath := appendPath(path, union.discriminator)
+	key, ok := obj.tree[union.discriminator]
+	if !ok {
+		v.errs = append(v.errs, ValidationError{
+			Path:     keyPath,
+			Rule:     RuleMissingKey,
+			Expected: KindString.String(),
+			Found:    KindInvalid,
+		})
+		return
+	}
+	if key.dataType != 0b0100 {
+		v.errs = append(v.errs, ValidationError{
+			Path:     keyPath,
+			Rule:     RuleTypeMismatch,
+			Expected: KindString.String(),
+			Found:    Kind(key.dataType),
+		})
+		return
+	}
+	i := sort.SearchStrings(union.names, *key.text)
+	if i == len(union.names) || union.names[i] != *key.text {
+		if union.fallback != nil {
+			v.node(union.fallback, withoutKey(obj, union.discriminator), path)
+			return
+		}
+		v.errs = append(v.errs, ValidationError{
+			Path:       keyPath,
+			Rule:       RuleConstraint,
+			Constraint: "discriminator",
+			Expected:   KindString.String(),
+			Found:      KindString,
+			Detail:     fmt.Sprintf("%q is not one of %s", *key.text, quoteAll(union.names)),
+		})
+		return
+	}
+
+	v.node(union.branches[i], withoutKey(obj, union.discriminator), path)
+}
+
+// withoutKey returns a tree with the children of obj except key, which is
+// left unchanged.
+func withoutKey(obj *ABITObject, key string) *ABITObject {
+	tree := &ABITObject{
+		dataType: obj.dataType,
+		tree:     make(map[string]*ABITObject, len(obj.tree)),
+	}
+	for k, child := range obj.tree {
+		if k != key {
+			tree.tree[k] = child
+		}
+	}
+	return tree
+}
+
+// quoteAll returns the strings quoted and separated by commas.
+func quoteAll(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+	return strings.Join(quoted, ", ")
+}
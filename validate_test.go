@@ -0,0 +1,231 @@
+package abit
+
+import (
+	"testing"
+)
+
+func TestLexiconValidate(t *testing.T) {
+	lex := InitLexicon(`{
+		"$lexicon": 1,
+		"id": "integer",
+		"name": {"type": "string", "maxLength": 4},
+		"items": {"type": "array", "items": {"price": {"type": "integer", "minimum": 0}, "note?": "string"}},
+		"pair?": ["integer", "string"]
+	}`)
+
+	tree, _ := NewABITObject(&[]byte{})
+	if errs := lex.Validate(tree); len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+
+	tree.Put("id", int64(1))
+	tree.Put("name", "meow")
+	items := NewABITArray()
+	for _, price := range []int64{3, 2, -1} {
+		item, _ := NewABITObject(&[]byte{})
+		item.Put("price", price)
+		items.Add(*item)
+	}
+	tree.Put("items", *items)
+	if errs := lex.Validate(tree); len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %v", errs)
+	}
+
+	tree.Put("id", "1")
+	tree.Put("name", "meowmeow")
+	tree.Put("extra", Null{})
+	pair := NewABITArray()
+	pair.Add("a")
+	tree.Put("pair", *pair)
+
+	expected := []ValidationError{
+		{Path: "id", Rule: RuleTypeMismatch, Expected: "integer", Found: KindString},
+		{Path: "name", Rule: RuleConstraint, Constraint: "maxLength", Expected: "string", Found: KindString, Detail: "length 8 is greater than 4"},
+		{Path: "pair", Rule: RuleConstraint, Constraint: "length", Expected: "array", Found: KindArray, Detail: "tuple has 1 values, expected 2"},
+		{Path: "pair[0]", Rule: RuleTypeMismatch, Expected: "integer", Found: KindString},
+		{Path: "extra", Rule: RuleUnexpectedKey, Found: KindNull},
+		{Path: "items[2].price", Rule: RuleConstraint, Constraint: "minimum", Expected: "integer", Found: KindInteger, Detail: "integer -1 is less than 0"},
+	}
+	errs := lex.Validate(tree)
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i := range expected {
+		if errs[i] != expected[i] {
+			t.Fatalf("error %d is %#v, expected %#v", i, errs[i], expected[i])
+		}
+	}
+	if lex.Matches(tree) {
+		t.Fatal("document with errors matches")
+	}
+
+	messages := map[string]ValidationError{
+		"missing key at items[0].price (expected integer)":           {Path: "items[0].price", Rule: RuleMissingKey, Expected: "integer", Found: KindInvalid},
+		"type mismatch at document root (expected tree, found null)": {Rule: RuleTypeMismatch, Expected: "tree", Found: KindNull},
+		"constraint maxLength at name: length 8 is greater than 4":   expected[1],
+	}
+	for message, err := range messages {
+		if err.Error() != message {
+			t.Fatalf("error message is %q, expected %q", err.Error(), message)
+		}
+	}
+}
+
+func TestLexiconUnion(t *testing.T) {
+	lex := parseTestLexicon(t, `{
+		"$lexicon": 1,
+		"id": "com.example.post",
+		"defs": {
+			"main": {
+				"value?": {"oneOf": ["integer", {"type": "string", "maxLength": 3}, {"type": "string", "minLength": 2}]},
+				"any?": {"anyOf": ["null", {"type": "integer", "minimum": 0}, {"type": "integer", "maximum": 10}]},
+				"embed?": {"oneOf": {
+					"image": {"$ref": "#/defs/image"},
+					"link": {"uri": {"type": "string", "format": "uri"}}
+				}, "discriminator": "$type"},
+				"open?": {"anyOf": {"image": {"$ref": "#/defs/image"}}, "discriminator": "$type", "fallback": {"uri": "string"}},
+				"shape?": {"anyOf": [{"$ref": "#/defs/image"}, {"uri": "string", "title": "string"}]}
+			},
+			"image": {"size": "integer", "alt?": "string"}
+		}
+	}`)
+
+	image := func(size interface{}) ABITObject {
+		return testTree(map[string]interface{}{"size": size})
+	}
+	typed := func(name interface{}, tree ABITObject) ABITObject {
+		tree.Put("$type", name)
+		return tree
+	}
+	link := func() ABITObject {
+		return testTree(map[string]interface{}{"uri": "https://example.com"})
+	}
+
+	checkValidation(t, lex, []lexiconCase{
+		{"value", int64(1), nil},
+		{"value", "a", nil},
+		{"value", "abcd", nil},
+		{"value", "ab", []ValidationError{{Path: "value", Rule: RuleConstraint, Constraint: "oneOf", Expected: "integer|string", Found: KindString, Detail: "value matches branches 1, 2"}}},
+		{"value", Null{}, []ValidationError{{Path: "value", Rule: RuleTypeMismatch, Expected: "integer|string", Found: KindNull}}},
+		{"any", int64(-5), nil},
+		{"any", int64(50), nil},
+		{"any", "a", []ValidationError{{Path: "any", Rule: RuleTypeMismatch, Expected: "null|integer", Found: KindString}}},
+		{"embed", typed("image", image(int64(1))), nil},
+		{"embed", typed("link", link()), nil},
+		{"embed", typed("image", image("1")), []ValidationError{{Path: "embed.size", Rule: RuleTypeMismatch, Expected: "integer", Found: KindString}}},
+		{"embed", typed("video", image(int64(1))), []ValidationError{{Path: "embed.$type", Rule: RuleConstraint, Constraint: "discriminator", Expected: "string", Found: KindString, Detail: `"video" is not one of "image", "link"`}}},
+		{"embed", typed(int64(1), image(int64(1))), []ValidationError{{Path: "embed.$type", Rule: RuleTypeMismatch, Expected: "string", Found: KindInteger}}},
+		{"embed", image(int64(1)), []ValidationError{{Path: "embed.$type", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid}}},
+		{"embed", "image", []ValidationError{{Path: "embed", Rule: RuleTypeMismatch, Expected: "tree", Found: KindString}}},
+		{"open", typed("image", image(int64(1))), nil},
+		{"open", typed("link", link()), nil},
+		{"open", typed("image", link()), []ValidationError{{Path: "open.uri", Rule: RuleUnexpectedKey, Found: KindString}, {Path: "open.size", Rule: RuleMissingKey, Expected: "integer", Found: KindInvalid}}},
+		{"open", typed("video", image(int64(1))), []ValidationError{{Path: "open.size", Rule: RuleUnexpectedKey, Found: KindInteger}, {Path: "open.uri", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid}}},
+		{"shape", image(int64(1)), nil},
+		{"shape", link(), []ValidationError{{Path: "shape.title", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid}}},
+	})
+
+	checkLexiconRoundTrip(t, lex)
+
+	checkInvalidLexicons(t, []string{
+		`{"$lexicon": 1, "a": {"oneOf": []}}`,
+		`{"$lexicon": 1, "a": {"oneOf": "string"}}`,
+		`{"$lexicon": 1, "a": {"oneOf": ["string"], "anyOf": ["string"]}}`,
+		`{"$lexicon": 1, "a": {"oneOf": ["string"], "discriminator": "$type"}}`,
+		`{"$lexicon": 1, "a": {"oneOf": {"x": "string"}, "discriminator": ""}}`,
+		`{"$lexicon": 1, "a": {"oneOf": {"x": "string"}, "discriminator": 1}}`,
+		`{"$lexicon": 1, "a": {"oneOf": ["string"], "fallback": "string"}}`,
+		`{"$lexicon": 1, "a": {"anyOf": ["strin"]}}`,
+		`{"$lexicon": 1, "defs": {"main": {}, "a": {"oneOf": ["string", {"$ref": "#/defs/a"}]}}}`,
+	})
+}
+
+func TestLexiconMap(t *testing.T) {
+	lex := parseTestLexicon(t, `{
+		"$lexicon": 1,
+		"labels?": {"type": "map|null", "values": "string", "keyPattern": "^[a-z]+$", "maxKeyLength": 5},
+		"extra?": {"type": "tree", "keys": {"name": "string"}, "additionalKeys": true},
+		"counts?": {"type": "tree", "keys": {"total": "integer"}, "additionalKeys": {"type": "integer", "minimum": 0}, "minKeyLength": 2},
+		"anything?": "any"
+	}`)
+
+	nested := testTree(map[string]interface{}{"a": testTree(map[string]interface{}{"b": Null{}})})
+
+	checkValidation(t, lex, []lexiconCase{
+		{"labels", Null{}, nil},
+		{"labels", testTree(map[string]interface{}{}), nil},
+		{"labels", testTree(map[string]interface{}{"color": "red", "size": "xl"}), nil},
+		{"labels", testTree(map[string]interface{}{"color": int64(1)}), []ValidationError{{Path: "labels.color", Rule: RuleTypeMismatch, Expected: "string", Found: KindInteger}}},
+		{"labels", testTree(map[string]interface{}{"Co": "a"}), []ValidationError{{Path: "labels.Co", Rule: RuleConstraint, Constraint: "keyPattern", Expected: "null|tree", Found: KindTree, Detail: `key "Co" does not match ^[a-z]+$`}}},
+		{"labels", testTree(map[string]interface{}{"colour": "a"}), []ValidationError{{Path: "labels.colour", Rule: RuleConstraint, Constraint: "maxKeyLength", Expected: "null|tree", Found: KindTree, Detail: "key length 6 is greater than 5"}}},
+		{"extra", testTree(map[string]interface{}{"name": "a", "b": nested}), nil},
+		{"extra", testTree(map[string]interface{}{"b": "c"}), []ValidationError{{Path: "extra.name", Rule: RuleMissingKey, Expected: "string", Found: KindInvalid}}},
+		{"counts", testTree(map[string]interface{}{"total": int64(-1), "ab": int64(2)}), nil},
+		{"counts", testTree(map[string]interface{}{"total": int64(1), "ab": int64(-2)}), []ValidationError{{Path: "counts.ab", Rule: RuleConstraint, Constraint: "minimum", Expected: "integer", Found: KindInteger, Detail: "integer -2 is less than 0"}}},
+		{"counts", testTree(map[string]interface{}{"total": int64(1), "a": int64(2)}), []ValidationError{{Path: "counts.a", Rule: RuleConstraint, Constraint: "minKeyLength", Expected: "tree", Found: KindTree, Detail: "key length 1 is less than 2"}}},
+		{"anything", nested, nil},
+		{"anything", []byte{1}, nil},
+	})
+
+	checkLexiconRoundTrip(t, lex)
+	open := InitLexicon(`{"$lexicon": 1, "defs": {"main": {"type": "tree", "keys": {"a": "string"}, "additionalKeys": "integer"}}}`)
+	doc := testTree(map[string]interface{}{"a": "b", "c": int64(1)})
+	if !open.Matches(&doc) {
+		t.Fatal("open root doesn't match")
+	}
+
+	checkInvalidLexicons(t, []string{
+		`{"$lexicon": 1, "a": {"type": "map"}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "keys": {}}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "additionalKeys": true}}`,
+		`{"$lexicon": 1, "a": {"type": "tree", "values": "string"}}`,
+		`{"$lexicon": 1, "a": {"type": "tree|map", "values": "string"}}`,
+		`{"$lexicon": 1, "a": {"type": "tree", "keyPattern": "a"}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "keyPattern": "("}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "maxKeyLength": 257}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "minKeyLength": 0}}`,
+		`{"$lexicon": 1, "a": {"type": "map", "values": "string", "minKeyLength": 3, "maxKeyLength": 2}}`,
+		`{"$lexicon": 1, "a": {"type": "tree", "additionalKeys": 1}}`,
+		`{"$lexicon": 1, "a": {"type": "string", "additionalKeys": true}}`,
+		`{"$lexicon": 1, "a": {"type": "any", "items": "string"}}`,
+		`{"$lexicon": 1, "a": "any|null"}`,
+		`{"$lexicon": 1, "a": "map"}`,
+	})
+}
+
+func TestLexiconEnum(t *testing.T) {
+	lex := parseTestLexicon(t, `{
+		"$lexicon": 1,
+		"status?": {"type": "string", "enum": ["draft", "published"]},
+		"version?": {"type": "integer", "const": 2},
+		"flag?": {"type": "boolean|null", "const": true},
+		"level?": {"type": "integer|string", "enum": [1, 2, "max"], "maximum": 1}
+	}`)
+
+	checkValidation(t, lex, []lexiconCase{
+		{"status", "draft", nil},
+		{"status", "deleted", []ValidationError{{Path: "status", Rule: RuleConstraint, Constraint: "enum", Expected: "string", Found: KindString, Detail: `"deleted" is not one of "draft", "published"`}}},
+		{"version", int64(2), nil},
+		{"version", int64(3), []ValidationError{{Path: "version", Rule: RuleConstraint, Constraint: "const", Expected: "integer", Found: KindInteger, Detail: "3 is not 2"}}},
+		{"flag", true, nil},
+		{"flag", Null{}, nil},
+		{"flag", false, []ValidationError{{Path: "flag", Rule: RuleConstraint, Constraint: "const", Expected: "null|boolean", Found: KindBoolean, Detail: "false is not true"}}},
+		{"level", int64(1), nil},
+		{"level", "max", nil},
+		{"level", int64(2), []ValidationError{{Path: "level", Rule: RuleConstraint, Constraint: "maximum", Expected: "integer|string", Found: KindInteger, Detail: "integer 2 is greater than 1"}}},
+		{"level", "1", []ValidationError{{Path: "level", Rule: RuleConstraint, Constraint: "enum", Expected: "integer|string", Found: KindString, Detail: `"1" is not one of 1, 2, "max"`}}},
+	})
+
+	checkLexiconRoundTrip(t, lex)
+
+	checkInvalidLexicons(t, []string{
+		`{"$lexicon": 1, "a": {"type": "string", "enum": []}}`,
+		`{"$lexicon": 1, "a": {"type": "string", "enum": "a"}}`,
+		`{"$lexicon": 1, "a": {"type": "string", "enum": [1]}}`,
+		`{"$lexicon": 1, "a": {"type": "string", "enum": [null]}}`,
+		`{"$lexicon": 1, "a": {"type": "integer", "enum": [1.5]}}`,
+		`{"$lexicon": 1, "a": {"type": "string", "enum": ["a"], "const": "a"}}`,
+		`{"$lexicon": 1, "a": {"type": "blob", "const": "a"}}`,
+		`{"$lexicon": 1, "a": {"type": "boolean", "const": [true]}}`,
+	})
+}
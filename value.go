@@ -0,0 +1,393 @@
+package abit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Kind is the type of a value in an ABIT document. Its values are the type
+// nibbles used in the binary format.
+type Kind uint8
+
+const (
+	KindNull    Kind = 0b0000
+	KindBoolean Kind = 0b0001
+	KindInteger Kind = 0b0010
+	KindBlob    Kind = 0b0011
+	KindString  Kind = 0b0100
+	KindArray   Kind = 0b0101
+	KindTree    Kind = 0b0110
+	// KindInvalid is returned for values that do not exist.
+	KindInvalid Kind = 0xff
+)
+
+// String returns the name of the kind as used in lexicons, such as "integer".
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "null"
+	case KindBoolean:
+		return "boolean"
+	case KindInteger:
+		return "integer"
+	case KindBlob:
+		return "blob"
+	case KindString:
+		return "string"
+	case KindArray:
+		return "array"
+	case KindTree:
+		return "tree"
+	case KindInvalid:
+		return "invalid"
+	}
+	return "kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+var (
+	// ErrKeyNotFound is returned when a key does not exist in a tree.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrIndexOutOfRange is returned when an index does not exist in an array.
+	ErrIndexOutOfRange = errors.New("index out of range")
+)
+
+// TypeMismatchError is returned when a value is not of the requested kind.
+type TypeMismatchError struct {
+	// Key of the value, if it was fetched from a tree.
+	Key string
+	// Index of the value, or -1 if it was fetched from a tree.
+	Index int64
+	// Expected is the kind that was requested.
+	Expected Kind
+	// Found is the kind of the value.
+	Found Kind
+}
+
+func (e *TypeMismatchError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("value of key %q is %s, not %s", e.Key, e.Found, e.Expected)
+	}
+	return fmt.Sprintf("value at index %d is %s, not %s", e.Index, e.Found, e.Expected)
+}
+
+// Value is a value fetched from a tree or an array with Lookup.
+type Value struct {
+	obj   *ABITObject
+	key   string
+	index int64
+}
+
+// Kind returns the kind of the value.
+func (v Value) Kind() Kind {
+	if v.obj == nil {
+		return KindInvalid
+	}
+	return Kind(v.obj.dataType)
+}
+
+// Interface returns the value as abit.Null, bool, int64, *[]byte, *string,
+// *ABITArray or *ABITObject, like the values returned by the Get functions.
+func (v Value) Interface() interface{} {
+	switch v.Kind() {
+	case KindNull:
+		return Null{}
+	case KindBoolean:
+		return v.obj.boolean
+	case KindInteger:
+		return v.obj.integer
+	case KindBlob:
+		return v.obj.blob
+	case KindString:
+		return v.obj.text
+	case KindArray:
+		return v.obj.array
+	case KindTree:
+		return v.obj
+	}
+	return nil
+}
+
+func (v Value) expect(kind Kind) error {
+	if v.Kind() != kind {
+		return &TypeMismatchError{
+			Key:      v.key,
+			Index:    v.index,
+			Expected: kind,
+			Found:    v.Kind(),
+		}
+	}
+	return nil
+}
+
+// Null returns an error unless the value is null.
+func (v Value) Null() (Null, error) {
+	return Null{}, v.expect(KindNull)
+}
+
+// Bool returns the value if it is a boolean.
+func (v Value) Bool() (bool, error) {
+	if err := v.expect(KindBoolean); err != nil {
+		return false, err
+	}
+	return v.obj.boolean, nil
+}
+
+// Integer returns the value if it is an integer.
+func (v Value) Integer() (int64, error) {
+	if err := v.expect(KindInteger); err != nil {
+		return 0, err
+	}
+	return v.obj.integer, nil
+}
+
+// Blob returns the value if it is a blob.
+func (v Value) Blob() ([]byte, error) {
+	if err := v.expect(KindBlob); err != nil {
+		return nil, err
+	}
+	return *v.obj.blob, nil
+}
+
+// Text returns the value if it is a string.
+func (v Value) Text() (string, error) {
+	if err := v.expect(KindString); err != nil {
+		return "", err
+	}
+	return *v.obj.text, nil
+}
+
+// Array returns the value if it is an array.
+func (v Value) Array() (*ABITArray, error) {
+	if err := v.expect(KindArray); err != nil {
+		return nil, err
+	}
+	return v.obj.array, nil
+}
+
+// Tree returns the value if it is a tree.
+func (v Value) Tree() (*ABITObject, error) {
+	if err := v.expect(KindTree); err != nil {
+		return nil, err
+	}
+	return v.obj, nil
+}
+
+// Kind returns the kind of the ABITObject.
+func (t *ABITObject) Kind() Kind {
+	return Kind(t.dataType)
+}
+
+// Lookup fetches the value associated with key.
+//
+// ok is false if the ABITObject is not a tree or the key does not exist.
+//
+// # Example
+//
+//	if v, ok := tree.Lookup("name"); ok && v.Kind() == abit.KindString {
+//		name, _ := v.Text()
+//	}
+func (t *ABITObject) Lookup(key string) (Value, bool) {
+	if t.dataType != 0b0110 {
+		return Value{}, false
+	}
+	o, ok := t.tree[key]
+	if !ok {
+		return Value{}, false
+	}
+	return Value{obj: o, key: key, index: -1}, true
+}
+
+// Has reports whether key exists in the tree.
+func (t *ABITObject) Has(key string) bool {
+	_, ok := t.Lookup(key)
+	return ok
+}
+
+// KindOf returns the kind of the value associated with key, or KindInvalid
+// if the key does not exist.
+func (t *ABITObject) KindOf(key string) Kind {
+	v, _ := t.Lookup(key)
+	return v.Kind()
+}
+
+func (t *ABITObject) lookup(key string) (Value, error) {
+	if t.dataType != 0b0110 {
+		return Value{}, fmt.Errorf("ABITObject is not of type tree")
+	}
+	v, ok := t.Lookup(key)
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	return v, nil
+}
+
+// TryGetNull returns an error unless the value associated with key is null.
+//
+// The error is ErrKeyNotFound if the key does not exist or a
+// *TypeMismatchError if the value is of another kind, the same goes for
+// all TryGet functions.
+func (t *ABITObject) TryGetNull(key string) (Null, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return Null{}, err
+	}
+	return v.Null()
+}
+
+// TryGetBool fetches bool assosiated with key.
+func (t *ABITObject) TryGetBool(key string) (bool, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+// TryGetInteger fetches integer assosiated with key.
+func (t *ABITObject) TryGetInteger(key string) (int64, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return 0, err
+	}
+	return v.Integer()
+}
+
+// TryGetBlob fetches blob assosiated with key.
+func (t *ABITObject) TryGetBlob(key string) ([]byte, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.Blob()
+}
+
+// TryGetString fetches string assosiated with key.
+//
+// # Example
+//
+//	name, err := tree.TryGetString("name")
+//	var mismatch *abit.TypeMismatchError
+//	switch {
+//	case errors.Is(err, abit.ErrKeyNotFound):
+//		// Handle missing key here
+//	case errors.As(err, &mismatch):
+//		// Handle value of the wrong kind here
+//	}
+func (t *ABITObject) TryGetString(key string) (string, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return "", err
+	}
+	return v.Text()
+}
+
+// TryGetArray fetches array assosiated with key.
+func (t *ABITObject) TryGetArray(key string) (*ABITArray, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.Array()
+}
+
+// TryGetTree fetches tree assosiated with key.
+func (t *ABITObject) TryGetTree(key string) (*ABITObject, error) {
+	v, err := t.lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	return v.Tree()
+}
+
+// Lookup fetches the value at index.
+//
+// ok is false if the index is out of range.
+func (a *ABITArray) Lookup(index int64) (Value, bool) {
+	if index < 0 || int(index) >= len(a.array) {
+		return Value{}, false
+	}
+	return Value{obj: a.array[index], index: index}, true
+}
+
+// KindOf returns the kind of the value at index, or KindInvalid if the index
+// is out of range.
+func (a *ABITArray) KindOf(index int64) Kind {
+	v, _ := a.Lookup(index)
+	return v.Kind()
+}
+
+func (a *ABITArray) lookup(index int64) (Value, error) {
+	v, ok := a.Lookup(index)
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, index)
+	}
+	return v, nil
+}
+
+// TryGetNull returns an error unless the value at index is null.
+//
+// The error is ErrIndexOutOfRange if the index does not exist or a
+// *TypeMismatchError if the value is of another kind, the same goes for
+// all TryGet functions.
+func (a *ABITArray) TryGetNull(index int64) (Null, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return Null{}, err
+	}
+	return v.Null()
+}
+
+// TryGetBool fetches bool at index.
+func (a *ABITArray) TryGetBool(index int64) (bool, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return false, err
+	}
+	return v.Bool()
+}
+
+// TryGetInteger fetches integer at index.
+func (a *ABITArray) TryGetInteger(index int64) (int64, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return 0, err
+	}
+	return v.Integer()
+}
+
+// TryGetBlob fetches blob at index.
+func (a *ABITArray) TryGetBlob(index int64) ([]byte, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return nil, err
+	}
+	return v.Blob()
+}
+
+// TryGetString fetches string at index.
+func (a *ABITArray) TryGetString(index int64) (string, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return "", err
+	}
+	return v.Text()
+}
+
+// TryGetArray fetches array at index.
+func (a *ABITArray) TryGetArray(index int64) (*ABITArray, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return nil, err
+	}
+	return v.Array()
+}
+
+// TryGetTree fetches tree at index.
+func (a *ABITArray) TryGetTree(index int64) (*ABITObject, error) {
+	v, err := a.lookup(index)
+	if err != nil {
+		return nil, err
+	}
+	return v.Tree()
+}
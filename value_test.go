@@ -0,0 +1,73 @@
+package abit
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTryGet(t *testing.T) {
+	tree := decoderTestTree()
+
+	if !tree.Has("string obj") || tree.Has("missing") {
+		t.Fatal("Has returned wrong result")
+	}
+	if tree.KindOf("string obj") != KindString || tree.KindOf("missing") != KindInvalid {
+		t.Fatal("KindOf returned wrong kind")
+	}
+
+	s, err := tree.TryGetString("string obj")
+	if err != nil || s != "Hello 💀" {
+		t.Fatal("incorrect value")
+	}
+	if _, err := tree.TryGetString("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+
+	var mismatch *TypeMismatchError
+	_, err = tree.TryGetInteger("string obj")
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected *TypeMismatchError, got %v", err)
+	}
+	if mismatch.Key != "string obj" || mismatch.Expected != KindInteger || mismatch.Found != KindString {
+		t.Fatalf("incorrect mismatch: %+v", mismatch)
+	}
+
+	v, ok := tree.Lookup("integer obj")
+	if !ok || v.Kind() != KindInteger || v.Interface().(int64) != -69696969420 {
+		t.Fatal("incorrect value")
+	}
+
+	arr, err := tree.TryGetArray("array obj")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if arr.KindOf(0) != KindString || arr.KindOf(2) != KindArray || arr.KindOf(3) != KindInvalid {
+		t.Fatal("KindOf returned wrong kind")
+	}
+	if i, err := arr.TryGetInteger(1); err != nil || i != 2 {
+		t.Fatal("incorrect value")
+	}
+	if _, err := arr.TryGetBool(3); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	if _, err := arr.TryGetBool(-1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	_, err = arr.TryGetTree(0)
+	if !errors.As(err, &mismatch) || mismatch.Index != 0 || mismatch.Found != KindString {
+		t.Fatalf("expected *TypeMismatchError, got %v", err)
+	}
+
+	nested, err := tree.TryGetTree("nesty")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := nested.TryGetNull("thing"); err == nil {
+		t.Fatal("string should not be null")
+	}
+	if _, err := tree.TryGetNull("null obj"); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	shouldPanic(t, func() { tree.GetString("missing") })
+}
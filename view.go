@@ -0,0 +1,277 @@
+package abit
+
+import (
+	"fmt"
+)
+
+// View is a read-only view of an encoded ABIT document or of a value inside
+// one. It reads the encoded bytes on demand instead of decoding the whole
+// document, blobs and strings are returned as sub-slices of the document.
+//
+// A View only checks the parts of the document it reads, use NewABITObject
+// or a TokenReader to check a whole document.
+//
+// # Example
+//
+//	v := abit.NewView(doc)
+//	name, err := v.Get("profile")
+//	if err == nil {
+//		text, err := name.Get("name")
+//		// Code handling the name here
+//	}
+type View struct {
+	kind Kind
+	// raw holds the encoded value, body the contents of a blob, string,
+	// array or tree. For the document itself both hold the whole document.
+	raw  []byte
+	body []byte
+	// base is the position of body in the document.
+	base    int64
+	boolean bool
+	integer int64
+	// key or index the value was fetched with, index is -1 for keys.
+	key   string
+	index int64
+}
+
+// NewView returns a View of the document in doc.
+func NewView(doc []byte) View {
+	return View{
+		kind:  KindTree,
+		raw:   doc,
+		body:  doc,
+		index: -1,
+	}
+}
+
+// viewAt returns a View of the value starting at offset in blob, and the
+// offset just past the value. base is the position of blob in the document.
+func viewAt(blob *[]byte, offset int64, base int64) (View, int64, error) {
+	typ, err := decodeType(blob, offset)
+	if err != nil {
+		return View{}, 0, locateDecodeError(err, base, "")
+	}
+	v := View{
+		kind: Kind(typ),
+	}
+	var end int64
+	switch typ {
+	case 0b0000:
+		end, err = decodeNull(blob, offset)
+	case 0b0001:
+		v.boolean, end, err = decodeBoolean(blob, offset)
+	case 0b0010:
+		v.integer, end, err = decodeInteger(blob, offset, 8)
+	case 0b0011, 0b0100, 0b0101, 0b0110:
+		v.body, end, err = decodeBlob(blob, offset)
+		v.base = base + end - int64(len(v.body))
+	default:
+		err := newDecodeError(offset, ErrInvalidType)
+		err.Found = Kind(typ)
+		return View{}, 0, locateDecodeError(err, base, "")
+	}
+	if err != nil {
+		return View{}, 0, locateDecodeError(err, base, "")
+	}
+	v.raw = (*blob)[offset:end]
+	return v, end, nil
+}
+
+// Kind returns the kind of the value.
+func (v View) Kind() Kind {
+	return v.kind
+}
+
+// Raw returns the encoded bytes of the value. For the document itself this is
+// the whole document.
+func (v View) Raw() []byte {
+	return v.raw
+}
+
+func (v View) expect(kind Kind) error {
+	if v.kind != kind {
+		return &TypeMismatchError{
+			Key:      v.key,
+			Index:    v.index,
+			Expected: kind,
+			Found:    v.kind,
+		}
+	}
+	return nil
+}
+
+// each calls fn with every key, or "" in arrays, and value in a tree or an
+// array until fn returns false.
+func (v View) each(fn func(key string, value View) bool) error {
+	var offset int64 = 0
+	var index int64 = 0
+	for int(offset) < len(v.body) {
+		var key string
+		if v.kind == KindTree {
+			var err error
+			key, offset, err = decodeKey(&v.body, offset)
+			if err != nil {
+				return locateDecodeError(err, v.base, "")
+			}
+		}
+		value, end, err := viewAt(&v.body, offset, v.base)
+		if err != nil {
+			if v.kind == KindTree {
+				return locateDecodeError(err, 0, key)
+			}
+			return locateDecodeError(err, 0, indexSegment(int(index)))
+		}
+		offset = end
+		value.key = key
+		value.index = -1
+		if v.kind == KindArray {
+			value.index = index
+		}
+		index++
+		if !fn(key, value) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Range calls fn for every value in a tree or an array, in the order they
+// are stored, until fn returns false. key is "" for values in arrays.
+func (v View) Range(fn func(key string, value View) bool) error {
+	if v.kind != KindTree && v.kind != KindArray {
+		return fmt.Errorf("%s is not a tree or an array", v.kind)
+	}
+	return v.each(fn)
+}
+
+// Len returns the number of keys in a tree or values in an array.
+func (v View) Len() (int, error) {
+	n := 0
+	err := v.Range(func(string, View) bool {
+		n++
+		return true
+	})
+	return n, err
+}
+
+// Keys returns the keys of a tree in the order they are stored.
+func (v View) Keys() ([]string, error) {
+	if err := v.expect(KindTree); err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	err := v.each(func(key string, _ View) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys, err
+}
+
+// Get returns the value associated with key in a tree.
+//
+// As keys are stored in canonical order, the search stops at the first key
+// that sorts after key.
+func (v View) Get(key string) (View, error) {
+	if err := v.expect(KindTree); err != nil {
+		return View{}, err
+	}
+	var found View
+	ok := false
+	err := v.each(func(k string, value View) bool {
+		if k == key {
+			found = value
+			ok = true
+		}
+		return keyCompare(k, key)
+	})
+	if err != nil {
+		return View{}, err
+	}
+	if !ok {
+		return View{}, fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+	return found, nil
+}
+
+// Index returns the value at index in an array.
+func (v View) Index(index int) (View, error) {
+	if err := v.expect(KindArray); err != nil {
+		return View{}, err
+	}
+	var found View
+	ok := false
+	i := 0
+	err := v.each(func(_ string, value View) bool {
+		if i == index {
+			found = value
+			ok = true
+			return false
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		return View{}, err
+	}
+	if !ok {
+		return View{}, fmt.Errorf("%w: %d", ErrIndexOutOfRange, index)
+	}
+	return found, nil
+}
+
+// Null returns an error unless the value is null.
+func (v View) Null() (Null, error) {
+	return Null{}, v.expect(KindNull)
+}
+
+// Bool returns the value if it is a boolean.
+func (v View) Bool() (bool, error) {
+	if err := v.expect(KindBoolean); err != nil {
+		return false, err
+	}
+	return v.boolean, nil
+}
+
+// Integer returns the value if it is an integer.
+func (v View) Integer() (int64, error) {
+	if err := v.expect(KindInteger); err != nil {
+		return 0, err
+	}
+	return v.integer, nil
+}
+
+// Blob returns the value if it is a blob. The blob is a sub-slice of the
+// document and must not be modified.
+func (v View) Blob() ([]byte, error) {
+	if err := v.expect(KindBlob); err != nil {
+		return nil, err
+	}
+	return v.body, nil
+}
+
+// StringBytes returns the UTF-8 bytes of the value if it is a string. The
+// bytes are a sub-slice of the document and must not be modified.
+func (v View) StringBytes() ([]byte, error) {
+	if err := v.expect(KindString); err != nil {
+		return nil, err
+	}
+	return v.body, nil
+}
+
+// Text returns a copy of the value if it is a string.
+func (v View) Text() (string, error) {
+	b, err := v.StringBytes()
+	return string(b), err
+}
+
+// Object decodes the value into an ABITObject if it is a tree.
+func (v View) Object() (*ABITObject, error) {
+	if err := v.expect(KindTree); err != nil {
+		return nil, err
+	}
+	tree, err := NewABITObject(&v.body)
+	if err != nil {
+		return nil, locateDecodeError(err, v.base, "")
+	}
+	return tree, nil
+}
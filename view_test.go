@@ -0,0 +1,104 @@
+package abit
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestView(t *testing.T) {
+	tree := decoderTestTree()
+	doc := tree.ToByteArray()
+	v := NewView(doc)
+
+	n, err := v.Len()
+	if err != nil || n != len(tree.Keys()) {
+		t.Fatalf("expected %d keys, got %d", len(tree.Keys()), n)
+	}
+	keys, err := v.Keys()
+	if err != nil || len(keys) != n || keys[0] != "nesty" {
+		t.Fatalf("incorrect keys: %v", keys)
+	}
+
+	s, err := v.Get("string obj")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	text, err := s.Text()
+	if err != nil || text != "Hello 💀" {
+		t.Fatal("incorrect value")
+	}
+
+	b, err := v.Get("blob obj")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	blob, err := b.Blob()
+	if err != nil || !bytes.Equal(blob, *tree.GetBlob("blob obj")) {
+		t.Fatal("incorrect value")
+	}
+	if &blob[0] != &doc[bytes.Index(doc, blob)] {
+		t.Fatal("blob should be a sub-slice of the document")
+	}
+
+	i, _ := v.Get("integer obj")
+	if n, err := i.Integer(); err != nil || n != -69696969420 {
+		t.Fatal("incorrect value")
+	}
+	if _, err := i.Bool(); err == nil {
+		t.Fatal("integer should not be a boolean")
+	}
+
+	nesty, _ := v.Get("nesty")
+	things, err := nesty.Get("things")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	inner, err := things.Index(2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	first, _ := inner.Index(0)
+	if b, err := first.Bool(); err != nil || b {
+		t.Fatal("incorrect value")
+	}
+
+	if _, err := v.Get("missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := things.Index(3); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected ErrIndexOutOfRange, got %v", err)
+	}
+	var mismatch *TypeMismatchError
+	if _, err := things.Get("key"); !errors.As(err, &mismatch) || mismatch.Found != KindArray {
+		t.Fatalf("expected *TypeMismatchError, got %v", err)
+	}
+
+	obj, err := nesty.Object()
+	if err != nil || !bytes.Equal(obj.ToByteArray(), tree.GetTree("nesty").ToByteArray()) {
+		t.Fatal("incorrect tree")
+	}
+}
+
+func TestViewCorrupt(t *testing.T) {
+	doc, offset := corruptedDocument(t)
+	v := NewView(doc)
+
+	profile, err := v.Get("profile")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	tags, err := profile.Get("tags")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	// Values before the corrupt one can still be read.
+	if _, err := tags.Index(2); err != nil {
+		t.Fatal(err.Error())
+	}
+	_, err = tags.Index(3)
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Offset != offset || !errors.Is(err, ErrInvalidType) {
+		t.Fatalf("expected ErrInvalidType at %d, got %v", offset, err)
+	}
+}